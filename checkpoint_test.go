@@ -0,0 +1,103 @@
+package tchannel
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// fakeChecksumChannel is a minimal outFragmentChannel + checksumSource that exercises
+// Checkpoint/ResumeMultiPartWriter without needing a real Frame/Message, neither of which
+// this snapshot defines. beginFragment/flushFragment are never called by the paths under
+// test here, so they just report that fact if they ever are.
+type fakeChecksumChannel struct {
+	checksum Checksum
+}
+
+func (f *fakeChecksumChannel) beginFragment() (*outFragment, error) {
+	return nil, errors.New("fakeChecksumChannel.beginFragment unexpectedly called")
+}
+
+func (f *fakeChecksumChannel) flushFragment(frag *outFragment, last bool) error {
+	return errors.New("fakeChecksumChannel.flushFragment unexpectedly called")
+}
+
+func (f *fakeChecksumChannel) sendControl(ctrl ControlFragment) error {
+	return errors.New("fakeChecksumChannel.sendControl unexpectedly called")
+}
+
+func (f *fakeChecksumChannel) currentChecksum() Checksum {
+	return f.checksum
+}
+
+// nonResumableChannel implements outFragmentChannel but not checksumSource
+type nonResumableChannel struct{}
+
+func (nonResumableChannel) beginFragment() (*outFragment, error) { return nil, nil }
+func (nonResumableChannel) flushFragment(*outFragment, bool) error { return nil }
+func (nonResumableChannel) sendControl(ControlFragment) error     { return nil }
+
+func TestMultiPartWriterCheckpointRoundTrip(t *testing.T) {
+	ch := &fakeChecksumChannel{checksum: newCrc32cChecksum()}
+	ch.checksum.Add([]byte("hello world"))
+
+	w := newMultiPartWriter(ch)
+	w.recordDispatched(3)
+	w.recordDispatched(1) // out of order, under pipelining - must not move Seq backward
+	w.recordDispatched(5)
+
+	cp, err := w.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	if !cp.HasSeq || cp.Seq != 5 {
+		t.Fatalf("Checkpoint Seq = %d, HasSeq = %v; want 5, true", cp.Seq, cp.HasSeq)
+	}
+	if cp.ChecksumType != ChecksumTypeCrc32C {
+		t.Fatalf("Checkpoint ChecksumType = %v; want ChecksumTypeCrc32C", cp.ChecksumType)
+	}
+
+	resumeCh := &fakeChecksumChannel{checksum: newCrc32cChecksum()}
+	resumed, err := ResumeMultiPartWriter(resumeCh, cp)
+	if err != nil {
+		t.Fatalf("ResumeMultiPartWriter: %v", err)
+	}
+
+	if resumed.nextSeq != cp.Seq+1 {
+		t.Fatalf("resumed.nextSeq = %d; want %d", resumed.nextSeq, cp.Seq+1)
+	}
+	if !bytes.Equal(resumeCh.checksum.Sum(), ch.checksum.Sum()) {
+		t.Fatalf("resumed checksum = %x; want %x", resumeCh.checksum.Sum(), ch.checksum.Sum())
+	}
+}
+
+func TestMultiPartWriterCheckpointNotResumable(t *testing.T) {
+	w := newMultiPartWriter(nonResumableChannel{})
+	if _, err := w.Checkpoint(); err != ErrNotResumable {
+		t.Fatalf("Checkpoint error = %v; want ErrNotResumable", err)
+	}
+}
+
+func TestResumeMultiPartWriterChecksumTypeMismatch(t *testing.T) {
+	ch := &fakeChecksumChannel{checksum: newCrc32cChecksum()}
+	ch.checksum.Add([]byte("payload"))
+	w := newMultiPartWriter(ch)
+	w.recordDispatched(0)
+
+	cp, err := w.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+
+	mismatched := &fakeChecksumChannel{checksum: newXXHash64Checksum()}
+	if _, err := ResumeMultiPartWriter(mismatched, cp); err != ErrNotResumable {
+		t.Fatalf("ResumeMultiPartWriter error = %v; want ErrNotResumable", err)
+	}
+}
+
+func TestResumeMultiPartWriterChannelNotResumable(t *testing.T) {
+	cp := StreamCheckpoint{Seq: 1, HasSeq: true, ChecksumType: ChecksumTypeCrc32C}
+	if _, err := ResumeMultiPartWriter(nonResumableChannel{}, cp); err != ErrNotResumable {
+		t.Fatalf("ResumeMultiPartWriter error = %v; want ErrNotResumable", err)
+	}
+}
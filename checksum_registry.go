@@ -0,0 +1,220 @@
+package tchannel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sync"
+
+	"github.com/cespare/xxhash"
+)
+
+// ChecksumTypeCrc32C is the checksum type code for CRC32 computed with the Castagnoli
+// polynomial, which most modern CPUs can compute with a dedicated SSE4.2 instruction
+const ChecksumTypeCrc32C ChecksumType = 0x02
+
+// ChecksumTypeXXHash64 is the checksum type code for 64-bit xxhash, a non-cryptographic
+// hash that outperforms CRC32/CRC32C in pure software on CPUs without SSE4.2
+const ChecksumTypeXXHash64 ChecksumType = 0x03
+
+// ErrUnregisteredChecksum is returned when a peer, or a local caller, references a
+// checksum type code that no ChecksumRegistry entry covers
+type ErrUnregisteredChecksum struct {
+	TypeCode ChecksumType
+}
+
+func (e ErrUnregisteredChecksum) Error() string {
+	return fmt.Sprintf("unregistered checksum type %d", e.TypeCode)
+}
+
+// checksumRegistryEntry records how to construct and size a registered checksum type
+type checksumRegistryEntry struct {
+	size       int
+	factory    func() Checksum
+	negotiable bool
+}
+
+// ChecksumRegistry lets applications add Checksum implementations - e.g. hardware
+// accelerated CRC32C, or xxhash - without forking the package, in place of the fixed set
+// that ChecksumType.New already knows about.  Entries registered as negotiable may
+// replace an in-progress message's checksum type mid-stream; see RegisterNegotiable.
+type ChecksumRegistry struct {
+	mut     sync.RWMutex
+	entries map[ChecksumType]checksumRegistryEntry
+	order   []ChecksumType
+}
+
+// NewChecksumRegistry creates an empty ChecksumRegistry
+func NewChecksumRegistry() *ChecksumRegistry {
+	return &ChecksumRegistry{entries: make(map[ChecksumType]checksumRegistryEntry)}
+}
+
+// Register adds or replaces the Checksum implementation used for typeCode. size is the
+// number of bytes the checksum occupies on the wire; factory constructs a fresh,
+// zero-valued Checksum for a new fragment.  Types registered this way are not
+// considered safe to switch to mid-message; see RegisterNegotiable.
+func (r *ChecksumRegistry) Register(typeCode ChecksumType, size int, factory func() Checksum) {
+	r.register(typeCode, size, factory, false)
+}
+
+// RegisterNegotiable is like Register, but additionally flags typeCode as safe for a
+// continuation fragment to switch to mid-message: newInboundFragment allows a
+// continuation whose checksum type differs from the one established by the message's
+// first fragment, as long as both the old and new types are negotiable, instead of
+// failing with ErrMismatchedChecksumTypes.  This is meant for cases like losing access to
+// hardware CRC32C acceleration partway through a large Arg3 stream.
+func (r *ChecksumRegistry) RegisterNegotiable(typeCode ChecksumType, size int, factory func() Checksum) {
+	r.register(typeCode, size, factory, true)
+}
+
+func (r *ChecksumRegistry) register(typeCode ChecksumType, size int, factory func() Checksum, negotiable bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if _, exists := r.entries[typeCode]; !exists {
+		r.order = append(r.order, typeCode)
+	}
+	r.entries[typeCode] = checksumRegistryEntry{size: size, factory: factory, negotiable: negotiable}
+}
+
+// New constructs a Checksum for typeCode, returning an ErrUnregisteredChecksum if nothing
+// has been registered for it rather than panicking
+func (r *ChecksumRegistry) New(typeCode ChecksumType) (Checksum, error) {
+	r.mut.RLock()
+	entry, ok := r.entries[typeCode]
+	r.mut.RUnlock()
+
+	if !ok {
+		return nil, ErrUnregisteredChecksum{TypeCode: typeCode}
+	}
+	return entry.factory(), nil
+}
+
+// Size returns the wire size, in bytes, of typeCode's checksum. ok is false if typeCode
+// isn't registered.
+func (r *ChecksumRegistry) Size(typeCode ChecksumType) (size int, ok bool) {
+	r.mut.RLock()
+	entry, ok := r.entries[typeCode]
+	r.mut.RUnlock()
+	return entry.size, ok
+}
+
+// IsNegotiable returns true if typeCode was registered via RegisterNegotiable
+func (r *ChecksumRegistry) IsNegotiable(typeCode ChecksumType) bool {
+	r.mut.RLock()
+	entry, ok := r.entries[typeCode]
+	r.mut.RUnlock()
+	return ok && entry.negotiable
+}
+
+// PreferenceOrder returns the registered checksum type codes in registration order,
+// which callers treat as strongest-preferred-first when advertising support during the
+// init handshake
+func (r *ChecksumRegistry) PreferenceOrder() []ChecksumType {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	order := make([]ChecksumType, len(r.order))
+	copy(order, r.order)
+	return order
+}
+
+// crc32cChecksum computes CRC32 using the Castagnoli polynomial (ISCSI), which golang's
+// hash/crc32 computes using the SSE4.2 CRC32 instruction when the host CPU supports it
+type crc32cChecksum struct {
+	hash uint32
+}
+
+func newCrc32cChecksum() Checksum {
+	return &crc32cChecksum{}
+}
+
+func (c *crc32cChecksum) Add(b []byte) {
+	c.hash = crc32.Update(c.hash, crc32.MakeTable(crc32.Castagnoli), b)
+}
+
+func (c *crc32cChecksum) Sum() []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], c.hash)
+	return buf[:]
+}
+
+func (c *crc32cChecksum) TypeCode() ChecksumType {
+	return ChecksumTypeCrc32C
+}
+
+// MarshalState implements SnapshotableChecksum; a running CRC32C is just its accumulator
+func (c *crc32cChecksum) MarshalState() ([]byte, error) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], c.hash)
+	return buf[:], nil
+}
+
+// UnmarshalState implements SnapshotableChecksum
+func (c *crc32cChecksum) UnmarshalState(state []byte) error {
+	if len(state) != 4 {
+		return fmt.Errorf("crc32c: invalid checkpoint state length %d", len(state))
+	}
+	c.hash = binary.BigEndian.Uint32(state)
+	return nil
+}
+
+// xxhash64Checksum computes a 64-bit xxhash, a non-cryptographic hash that is
+// considerably faster than CRC32C in pure software
+type xxhash64Checksum struct {
+	digest *xxhash.Digest
+}
+
+func newXXHash64Checksum() Checksum {
+	return &xxhash64Checksum{digest: xxhash.New()}
+}
+
+func (c *xxhash64Checksum) Add(b []byte) {
+	c.digest.Write(b)
+}
+
+func (c *xxhash64Checksum) Sum() []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], c.digest.Sum64())
+	return buf[:]
+}
+
+func (c *xxhash64Checksum) TypeCode() ChecksumType {
+	return ChecksumTypeXXHash64
+}
+
+// MarshalState implements SnapshotableChecksum. Unlike crc32cChecksum's bare uint32
+// accumulator, xxhash.Digest buffers a partial block internally between Write calls, so
+// snapshotting has to go through its own Binary(Un)Marshaler rather than just the 64-bit
+// running hash
+func (c *xxhash64Checksum) MarshalState() ([]byte, error) {
+	return c.digest.MarshalBinary()
+}
+
+// UnmarshalState implements SnapshotableChecksum
+func (c *xxhash64Checksum) UnmarshalState(state []byte) error {
+	return c.digest.UnmarshalBinary(state)
+}
+
+// SnapshotableChecksum is implemented by Checksum types that can save and later restore
+// their accumulated state, which Checkpoint/ResumeMultiPartWriter need in order to let a
+// multiPartWriter continue a running checksum across a reconnect. A Checksum that doesn't
+// implement this can still be used normally - only checkpointing is unavailable for it.
+type SnapshotableChecksum interface {
+	Checksum
+	MarshalState() ([]byte, error)
+	UnmarshalState(state []byte) error
+}
+
+// DefaultChecksumRegistry is the ChecksumRegistry used by connections that don't supply
+// their own via ChannelOptions.  It is pre-populated with the checksum type this package
+// has always understood (CRC32), plus CRC32C and XXHash64, both flagged negotiable so a
+// stream can downgrade mid-message if, say, CRC32C hardware support turns out to be
+// unavailable.  XXHash64 is preferred over CRC32C, which is preferred over CRC32.
+var DefaultChecksumRegistry = NewChecksumRegistry()
+
+func init() {
+	DefaultChecksumRegistry.RegisterNegotiable(ChecksumTypeXXHash64, 8, newXXHash64Checksum)
+	DefaultChecksumRegistry.RegisterNegotiable(ChecksumTypeCrc32C, 4, newCrc32cChecksum)
+	DefaultChecksumRegistry.Register(ChecksumTypeCrc32, ChecksumTypeCrc32.ChecksumSize(), ChecksumTypeCrc32.New)
+}
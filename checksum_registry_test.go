@@ -0,0 +1,136 @@
+package tchannel
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChecksumRegistryRegisterAndNew(t *testing.T) {
+	r := NewChecksumRegistry()
+
+	if _, err := r.New(ChecksumTypeCrc32C); err == nil {
+		t.Fatalf("New should fail for an unregistered type")
+	}
+
+	r.Register(ChecksumTypeCrc32C, 4, newCrc32cChecksum)
+
+	size, ok := r.Size(ChecksumTypeCrc32C)
+	if !ok || size != 4 {
+		t.Fatalf("Size = %d, %v; want 4, true", size, ok)
+	}
+
+	cs, err := r.New(ChecksumTypeCrc32C)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if cs.TypeCode() != ChecksumTypeCrc32C {
+		t.Fatalf("TypeCode = %v; want ChecksumTypeCrc32C", cs.TypeCode())
+	}
+}
+
+func TestChecksumRegistryIsNegotiable(t *testing.T) {
+	r := NewChecksumRegistry()
+	r.Register(ChecksumTypeCrc32C, 4, newCrc32cChecksum)
+	r.RegisterNegotiable(ChecksumTypeXXHash64, 8, newXXHash64Checksum)
+
+	if r.IsNegotiable(ChecksumTypeCrc32C) {
+		t.Errorf("Crc32C registered via Register should not be negotiable")
+	}
+	if !r.IsNegotiable(ChecksumTypeXXHash64) {
+		t.Errorf("XXHash64 registered via RegisterNegotiable should be negotiable")
+	}
+	if r.IsNegotiable(ChecksumTypeCrc32) {
+		t.Errorf("an unregistered type should not be negotiable")
+	}
+}
+
+func TestChecksumRegistryPreferenceOrder(t *testing.T) {
+	r := NewChecksumRegistry()
+	r.RegisterNegotiable(ChecksumTypeXXHash64, 8, newXXHash64Checksum)
+	r.RegisterNegotiable(ChecksumTypeCrc32C, 4, newCrc32cChecksum)
+	r.Register(ChecksumTypeCrc32, ChecksumTypeCrc32.ChecksumSize(), ChecksumTypeCrc32.New)
+
+	order := r.PreferenceOrder()
+	want := []ChecksumType{ChecksumTypeXXHash64, ChecksumTypeCrc32C, ChecksumTypeCrc32}
+	if len(order) != len(want) {
+		t.Fatalf("PreferenceOrder = %v; want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("PreferenceOrder[%d] = %v; want %v", i, order[i], want[i])
+		}
+	}
+
+	// Re-registering an existing type must not duplicate or move its position.
+	r.RegisterNegotiable(ChecksumTypeCrc32C, 4, newCrc32cChecksum)
+	order = r.PreferenceOrder()
+	if len(order) != len(want) {
+		t.Fatalf("re-registering an existing type changed PreferenceOrder to %v", order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("re-registering an existing type changed PreferenceOrder to %v", order)
+		}
+	}
+}
+
+func TestDefaultChecksumRegistryPreferenceOrder(t *testing.T) {
+	order := DefaultChecksumRegistry.PreferenceOrder()
+	want := []ChecksumType{ChecksumTypeXXHash64, ChecksumTypeCrc32C, ChecksumTypeCrc32}
+	if len(order) != len(want) {
+		t.Fatalf("PreferenceOrder = %v; want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("PreferenceOrder[%d] = %v; want %v", i, order[i], want[i])
+		}
+	}
+}
+
+func TestCrc32cChecksumMarshalUnmarshalState(t *testing.T) {
+	c := newCrc32cChecksum()
+	c.Add([]byte("hello "))
+	c.Add([]byte("world"))
+	want := c.Sum()
+
+	sc, ok := c.(SnapshotableChecksum)
+	if !ok {
+		t.Fatalf("crc32cChecksum does not implement SnapshotableChecksum")
+	}
+	state, err := sc.MarshalState()
+	if err != nil {
+		t.Fatalf("MarshalState: %v", err)
+	}
+
+	restored := newCrc32cChecksum().(SnapshotableChecksum)
+	if err := restored.UnmarshalState(state); err != nil {
+		t.Fatalf("UnmarshalState: %v", err)
+	}
+	if !bytes.Equal(restored.Sum(), want) {
+		t.Fatalf("Sum after restore = %x; want %x", restored.Sum(), want)
+	}
+}
+
+func TestXXHash64ChecksumMarshalUnmarshalState(t *testing.T) {
+	c := newXXHash64Checksum()
+	c.Add([]byte("hello "))
+	c.Add([]byte("world"))
+	want := c.Sum()
+
+	sc, ok := c.(SnapshotableChecksum)
+	if !ok {
+		t.Fatalf("xxhash64Checksum does not implement SnapshotableChecksum")
+	}
+	state, err := sc.MarshalState()
+	if err != nil {
+		t.Fatalf("MarshalState: %v", err)
+	}
+
+	restored := newXXHash64Checksum().(SnapshotableChecksum)
+	if err := restored.UnmarshalState(state); err != nil {
+		t.Fatalf("UnmarshalState: %v", err)
+	}
+	if !bytes.Equal(restored.Sum(), want) {
+		t.Fatalf("Sum after restore = %x; want %x", restored.Sum(), want)
+	}
+}
@@ -1,10 +1,13 @@
 package tchannel
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	_ "time"
@@ -37,8 +40,146 @@ var (
 	ErrConnectionWaitingOnPeerInit = errors.New("connection is waiting for the peer to sent init")
 	ErrSendBufferFull              = errors.New("connection send buffer is full, cannot send frame")
 	ErrRecvBufferFull              = errors.New("connection recv buffer is full, cannot recv frame")
+	ErrProtocolNotNegotiated       = errors.New("peer did not advertise the protocol required for this call")
 )
 
+// InitParamSubProtocols is the InitParams key under which a peer advertises the named
+// sub-protocols (and versions of each) it supports, so that a TChannel hosting multiple
+// wire-level extensions can tell which ones the other side understands.  The value is
+// encoded as a semicolon-separated list of "name:v1,v2,..." entries.
+const InitParamSubProtocols = "sp"
+
+// encodeSubProtocols renders the locally registered sub-protocols into an InitParams value
+func encodeSubProtocols(protocols map[string][]uint16) string {
+	var b bytes.Buffer
+	first := true
+	for name, versions := range protocols {
+		if !first {
+			b.WriteByte(';')
+		}
+		first = false
+
+		b.WriteString(name)
+		b.WriteByte(':')
+		for i, v := range versions {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Itoa(int(v)))
+		}
+	}
+	return b.String()
+}
+
+// parseSubProtocols parses the value of InitParamSubProtocols sent by a peer
+func parseSubProtocols(s string) map[string][]uint16 {
+	protocols := make(map[string][]uint16)
+	if s == "" {
+		return protocols
+	}
+
+	for _, entry := range strings.Split(s, ";") {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+
+		var versions []uint16
+		for _, vs := range strings.Split(parts[1], ",") {
+			v, err := strconv.Atoi(vs)
+			if err != nil {
+				continue
+			}
+			versions = append(versions, uint16(v))
+		}
+		protocols[parts[0]] = versions
+	}
+	return protocols
+}
+
+// intersectSubProtocols returns the sub-protocols (and, per protocol, the versions) that
+// both the local and remote peer advertised support for
+func intersectSubProtocols(local, remote map[string][]uint16) map[string][]uint16 {
+	intersection := make(map[string][]uint16)
+	for name, localVersions := range local {
+		remoteVersions, ok := remote[name]
+		if !ok {
+			continue
+		}
+
+		var common []uint16
+		for _, lv := range localVersions {
+			for _, rv := range remoteVersions {
+				if lv == rv {
+					common = append(common, lv)
+					break
+				}
+			}
+		}
+
+		if len(common) > 0 {
+			intersection[name] = common
+		}
+	}
+	return intersection
+}
+
+// InitParamChecksumTypes is the InitParams key under which a peer advertises the checksum
+// type codes it supports, in preference order (strongest first), so that two peers can
+// agree on a checksum algorithm other than the long-standing CRC32 default - e.g. a
+// hardware-accelerated CRC32C, or a faster software-only xxhash - without either side
+// having to guess what the other understands.  The value is a comma-separated list of
+// decimal type codes.
+const InitParamChecksumTypes = "cs"
+
+// encodeChecksumTypes renders typeCodes, in preference order, into an InitParams value
+func encodeChecksumTypes(typeCodes []ChecksumType) string {
+	var b bytes.Buffer
+	for i, t := range typeCodes {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(int(t)))
+	}
+	return b.String()
+}
+
+// parseChecksumTypes parses the value of InitParamChecksumTypes sent by a peer
+func parseChecksumTypes(s string) []ChecksumType {
+	if s == "" {
+		return nil
+	}
+
+	var typeCodes []ChecksumType
+	for _, ts := range strings.Split(s, ",") {
+		t, err := strconv.Atoi(ts)
+		if err != nil {
+			continue
+		}
+		typeCodes = append(typeCodes, ChecksumType(t))
+	}
+	return typeCodes
+}
+
+// negotiateChecksumType picks the first of local's checksum types (in local's preference
+// order) that remote also advertised support for.  ok is false if the two peers have no
+// checksum type in common, in which case the connection falls back to whatever the first
+// fragment of each message specifies, exactly as before checksum negotiation existed.
+func negotiateChecksumType(local, remote []ChecksumType) (typeCode ChecksumType, ok bool) {
+	remoteSet := make(map[ChecksumType]bool, len(remote))
+	for _, t := range remote {
+		remoteSet[t] = true
+	}
+
+	for _, t := range local {
+		if remoteSet[t] {
+			return t, true
+		}
+	}
+
+	return 0, false
+}
+
 // Options used during the creation of a TChannelConnection
 type TChannelConnectionOptions struct {
 	// The identity of the local peer
@@ -55,6 +196,14 @@ type TChannelConnectionOptions struct {
 
 	// The type of checksum to use when sending messages
 	ChecksumType ChecksumType
+
+	// The pool of scratch buffers used to assemble vectored arg3 writes that don't fit
+	// within a single fragment.  Defaults to DefaultFragmentBufferPool
+	FragmentBufferPool FragmentBufferPool
+
+	// ControlHandler, if set, is notified of inbound ControlFragments - keepalive pings,
+	// soft-cancels, and flow-update credit grants - multiplexed onto open messages
+	ControlHandler ControlHandler
 }
 
 // A connection to a remote peer.
@@ -73,6 +222,58 @@ type TChannelConnection struct {
 	activeResChs   map[uint32]chan<- *Frame // map of frame channels for incoming requests
 	inbound        *inboundCallPipeline
 	nextMessageId  uint32
+	doneCh         chan struct{}
+
+	// negotiatedProtocols is the set of named sub-protocols (and versions) that both this
+	// process and the peer advertised during the init handshake
+	negotiatedProtocols map[string][]uint16
+
+	// lastPeerError records why this connection was torn down, if it was torn down via peerError
+	lastPeerError *PeerError
+}
+
+// LastPeerError returns the reason this connection was closed, if it was closed due to
+// an identified failure (as opposed to a plain connectionError or a clean Close)
+func (c *TChannelConnection) LastPeerError() *PeerError {
+	var err *PeerError
+	c.withStateRLock(func() error {
+		err = c.lastPeerError
+		return nil
+	})
+	return err
+}
+
+// peerError tears down the connection following a specific, identified failure.  Unlike a
+// plain connectionError, it sends a final MessageTypeError frame carrying the disconnect
+// reason (best effort, since the socket may already be unusable) so the peer can log or
+// react instead of just observing a bare EOF, and it notifies any registered
+// PeerErrorHandler with the same information.
+func (c *TChannelConnection) peerError(reason DiscReason, cause error) error {
+	pErr := NewPeerError(reason, cause)
+
+	c.withStateLock(func() error {
+		c.lastPeerError = pErr
+		return nil
+	})
+
+	c.sendMessage(&ErrorMessage{
+		id:        0,
+		ErrorCode: ErrorCodeBadRequest,
+		Message:   pErr.Error(),
+	})
+
+	if c.ch != nil && c.ch.peerErrorHandler != nil {
+		c.ch.peerErrorHandler(c.remotePeerInfo, pErr)
+	}
+
+	return c.connectionError(pErr)
+}
+
+// SupportsProtocol returns true if the peer advertised support for the named sub-protocol
+// during the init handshake
+func (c *TChannelConnection) SupportsProtocol(name string) bool {
+	_, ok := c.negotiatedProtocols[name]
+	return ok
 }
 
 type connectionState int
@@ -139,6 +340,11 @@ func newConnection(ch *TChannel, conn net.Conn, initialState connectionState,
 		framePool = DefaultFramePool
 	}
 
+	bufferPool := opts.FragmentBufferPool
+	if bufferPool == nil {
+		bufferPool = DefaultFragmentBufferPool
+	}
+
 	c := &TChannelConnection{
 		ch:            ch,
 		log:           ch.log,
@@ -149,10 +355,17 @@ func newConnection(ch *TChannel, conn net.Conn, initialState connectionState,
 		activeResChs:  make(map[uint32]chan<- *Frame),
 		localPeerInfo: opts.PeerInfo,
 		checksumType:  opts.ChecksumType,
+		doneCh:        make(chan struct{}),
 	}
 
 	// TODO(mmihic): Possibly defer until after handshake is successful
 	c.inbound = newInboundCallPipeline(c.sendCh, &ch.handlers, framePool, ch.log)
+	c.inbound.setCompressors(ch.compressors)
+	c.inbound.setTraceReporter(ch.traceReporter)
+	c.inbound.setInterceptors(ch.interceptors)
+	c.inbound.setBufferPool(bufferPool)
+	c.inbound.setChecksumRegistry(ch.checksumRegistry)
+	c.inbound.setControlHandler(opts.ControlHandler)
 
 	go c.readFrames()
 	go c.writeFrames()
@@ -190,8 +403,10 @@ func (c *TChannelConnection) sendInit(ctx context.Context) error {
 	req := InitReq{initMessage{id: initMsgId}}
 	req.Version = CurrentProtocolVersion
 	req.InitParams = InitParams{
-		InitParamHostPort:    c.localPeerInfo.HostPort,
-		InitParamProcessName: c.localPeerInfo.ProcessName,
+		InitParamHostPort:      c.localPeerInfo.HostPort,
+		InitParamProcessName:   c.localPeerInfo.ProcessName,
+		InitParamSubProtocols:  encodeSubProtocols(c.ch.advertisedProtocols()),
+		InitParamChecksumTypes: encodeChecksumTypes(c.ch.advertisedChecksumTypes()),
 	}
 
 	if err := c.sendMessage(&req); err != nil {
@@ -207,11 +422,20 @@ func (c *TChannelConnection) sendInit(ctx context.Context) error {
 	}
 
 	if res.Version != CurrentProtocolVersion {
-		return c.connectionError(fmt.Errorf("Unsupported protocol version %d from peer", res.Version))
+		return c.peerError(DiscUnsupportedVersion,
+			fmt.Errorf("unsupported protocol version %d from peer", res.Version))
 	}
 
 	c.remotePeerInfo.HostPort = res.InitParams[InitParamHostPort]
 	c.remotePeerInfo.ProcessName = res.InitParams[InitParamProcessName]
+	c.negotiatedProtocols = intersectSubProtocols(c.ch.advertisedProtocols(),
+		parseSubProtocols(res.InitParams[InitParamSubProtocols]))
+	c.inbound.setNegotiatedProtocols(c.negotiatedProtocols)
+
+	if checksumType, ok := negotiateChecksumType(c.ch.advertisedChecksumTypes(),
+		parseChecksumTypes(res.InitParams[InitParamChecksumTypes])); ok {
+		c.inbound.setNegotiatedChecksumType(checksumType)
+	}
 
 	c.withStateLock(func() error {
 		if c.state == connectionWaitingToRecvInitRes {
@@ -220,9 +444,27 @@ func (c *TChannelConnection) sendInit(ctx context.Context) error {
 		return nil
 	})
 
+	c.ch.notifyProtocolHandlers(c)
+
 	return nil
 }
 
+// IsActive returns true if the connection has completed the init handshake and can still
+// be used to multiplex new outbound calls
+func (c *TChannelConnection) IsActive() bool {
+	var active bool
+	c.withStateRLock(func() error {
+		active = c.state == connectionActive
+		return nil
+	})
+	return active
+}
+
+// RemotePeerInfo returns the identity the remote peer advertised during the init handshake
+func (c *TChannelConnection) RemotePeerInfo() PeerInfo {
+	return c.remotePeerInfo
+}
+
 // Handles an incoming InitReq.  If we are waiting for the peer to send us an InitReq, and the
 // InitReq is valid, send a corresponding InitRes and mark ourselves as active
 func (c *TChannelConnection) handleInitReq(frame *Frame) {
@@ -236,24 +478,33 @@ func (c *TChannelConnection) handleInitReq(frame *Frame) {
 	var req InitReq
 	rbuf := typed.NewReadBuffer(frame.SizedPayload())
 	if err := req.read(rbuf); err != nil {
-		// TODO(mmihic): Technically probably a protocol error
-		c.connectionError(err)
+		c.peerError(DiscProtocolError, err)
 		return
 	}
 
 	if req.Version != CurrentProtocolVersion {
-		// TODO(mmihic): Send protocol error
-		c.connectionError(fmt.Errorf("Unsupported protocol version %d from peer", req.Version))
+		c.peerError(DiscUnsupportedVersion,
+			fmt.Errorf("unsupported protocol version %d from peer", req.Version))
 		return
 	}
 
 	c.remotePeerInfo.HostPort = req.InitParams[InitParamHostPort]
 	c.remotePeerInfo.ProcessName = req.InitParams[InitParamProcessName]
+	c.negotiatedProtocols = intersectSubProtocols(c.ch.advertisedProtocols(),
+		parseSubProtocols(req.InitParams[InitParamSubProtocols]))
+	c.inbound.setNegotiatedProtocols(c.negotiatedProtocols)
+
+	if checksumType, ok := negotiateChecksumType(c.ch.advertisedChecksumTypes(),
+		parseChecksumTypes(req.InitParams[InitParamChecksumTypes])); ok {
+		c.inbound.setNegotiatedChecksumType(checksumType)
+	}
 
 	res := InitRes{initMessage{id: frame.Header.Id}}
 	res.InitParams = InitParams{
-		InitParamHostPort:    c.localPeerInfo.HostPort,
-		InitParamProcessName: c.localPeerInfo.ProcessName,
+		InitParamHostPort:      c.localPeerInfo.HostPort,
+		InitParamProcessName:   c.localPeerInfo.ProcessName,
+		InitParamSubProtocols:  encodeSubProtocols(c.ch.advertisedProtocols()),
+		InitParamChecksumTypes: encodeChecksumTypes(c.ch.advertisedChecksumTypes()),
 	}
 	res.Version = CurrentProtocolVersion
 	if err := c.sendMessage(&res); err != nil {
@@ -269,6 +520,12 @@ func (c *TChannelConnection) handleInitReq(frame *Frame) {
 
 		return nil
 	})
+
+	// Now that we know the remote peer's advertised HostPort, register this connection
+	// with the pool so a server-initiated call back to the peer can reuse this socket
+	c.ch.pool.addInbound(c)
+
+	c.ch.notifyProtocolHandlers(c)
 }
 
 // Handles an incoming InitRes.  If we are waiting for the peer to send us an InitRes, forward the InitRes
@@ -352,11 +609,50 @@ func (c *TChannelConnection) connectionError(err error) error {
 
 	if doClose {
 		c.closeNetwork()
+		if c.ch.pool != nil && c.remotePeerInfo.HostPort != "" {
+			c.ch.pool.remove(c.remotePeerInfo.HostPort, c)
+		}
+		c.ch.inboundConns.Delete(c)
+		close(c.doneCh)
 	}
 
 	return err
 }
 
+// Done returns a channel that is closed once the connection has fully shut down
+func (c *TChannelConnection) Done() <-chan struct{} {
+	return c.doneCh
+}
+
+// startClose begins a graceful shutdown of the connection: the connection stops accepting
+// new inbound calls immediately, but is given until ctx is done to let any in-flight
+// inbound calls finish before the underlying socket is torn down.
+func (c *TChannelConnection) startClose(ctx context.Context) {
+	c.withStateLock(func() error {
+		if c.state == connectionActive {
+			c.state = connectionStartClose
+		}
+		return nil
+	})
+	c.inbound.beginClosing()
+
+	select {
+	case <-c.inbound.drained():
+	case <-ctx.Done():
+	case <-c.doneCh:
+		return
+	}
+
+	c.withStateLock(func() error {
+		if c.state != connectionClosed {
+			c.state = connectionInboundClosed
+		}
+		return nil
+	})
+
+	c.connectionError(ErrConnectionClosed)
+}
+
 // Closes the network connection and all network-related channels
 func (c *TChannelConnection) closeNetwork() {
 	// NB(mmihic): The sender goroutine	will exit once the connection is closed; no need to close
@@ -399,21 +695,20 @@ func (c *TChannelConnection) readFrames() {
 
 	for {
 		if _, err := fhBuf.FillFrom(c.conn, FrameHeaderSize); err != nil {
-			c.connectionError(err)
+			c.peerError(DiscReadError, err)
 			return
 		}
 
 		frame := c.framePool.Get()
 		if err := frame.Header.read(fhBuf); err != nil {
-			// TODO(mmihic): Should be a protocol error
-			c.connectionError(err)
+			c.peerError(DiscProtocolError, err)
 			return
 		}
 
 		c.log.Info("Recvd: id=%d:type=%d:sz=%d", frame.Header.Id, frame.Header.Type, frame.Header.Size)
 
 		if _, err := c.conn.Read(frame.SizedPayload()); err != nil {
-			c.connectionError(err)
+			c.peerError(DiscReadError, err)
 			return
 		}
 
@@ -435,7 +730,8 @@ func (c *TChannelConnection) readFrames() {
 		case MessageTypeError:
 			c.handleError(frame)
 		default:
-			// TODO(mmihic): Log and close connection with protocol error
+			c.peerError(DiscInvalidMsg, fmt.Errorf("unknown frame type %d", frame.Header.Type))
+			return
 		}
 	}
 }
@@ -443,25 +739,23 @@ func (c *TChannelConnection) readFrames() {
 // Main loop that pulls frames from the send channel and writes them to the connection.
 // Run in its own goroutine to prevent overlapping writes on the network socket.
 func (c *TChannelConnection) writeFrames() {
-	fhBuf := typed.NewWriteBufferWithSize(FrameHeaderSize)
+	var hdrBytes [FrameHeaderSize]byte
 	for f := range c.sendCh {
-		fhBuf.Reset()
-
 		c.log.Info("Send: id=%d:type=%d:sz=%d", f.Header.Id, f.Header.Type, f.Header.Size)
 		c.log.Info("Send: %s", hex.EncodeToString(f.SizedPayload()))
 
-		if err := f.Header.write(fhBuf); err != nil {
-			c.connectionError(NewWriteIOError("frame-header", err))
-			return
-		}
-
-		if _, err := fhBuf.FlushTo(c.conn); err != nil {
-			c.connectionError(NewWriteIOError("frame-header-flush", err))
+		hdrBuf := typed.NewWriteBuffer(hdrBytes[:])
+		if err := f.Header.write(hdrBuf); err != nil {
+			c.peerError(DiscWriteError, NewWriteIOError("frame-header", err))
 			return
 		}
 
-		if _, err := c.conn.Write(f.SizedPayload()); err != nil {
-			c.connectionError(NewWriteIOError("frame-payload", err))
+		// Hand the header and payload to the socket together as net.Buffers, so the
+		// kernel sees them as one writev instead of this goroutine paying for two
+		// separate write syscalls per frame
+		bufs := net.Buffers{hdrBytes[:], f.SizedPayload()}
+		if _, err := bufs.WriteTo(c.conn); err != nil {
+			c.peerError(DiscWriteError, NewWriteIOError("frame-write", err))
 			return
 		}
 
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"sync"
 
 	"code.uber.internal/personal/mmihic/tchannel-go/typed"
 )
@@ -26,13 +27,165 @@ var (
 	errAlignedAtEndOfOpenFragment = errors.New("impl error; align-at-end of open fragment")
 	errNoOpenChunk                = errors.New("impl error, writeChunkData or endChunk called with no open chunk")
 	errChunkAlreadyOpen           = errors.New("impl error, beginChunk called with an already open chunk")
+
+	// Peer sent a fragment that isn't a ControlFragment to code that expected one, or vice versa
+	errNotControlFragment = errors.New("fragment is not a control fragment")
 )
 
 const (
 	// Flag indicating there are more fragments to come
 	flagMoreFragments = 0x01
+
+	// Flag indicating this fragment is a ControlFragment rather than a data fragment: it
+	// carries zero chunks and is not part of the flagMoreFragments bookkeeping for the
+	// message it's multiplexed onto
+	flagControlFragment = 0x02
+)
+
+// ControlFragmentType identifies the kind of a ControlFragment
+type ControlFragmentType byte
+
+const (
+	// ControlPing is a keepalive sent with no expectation of carrying data; the peer
+	// should respond with ControlPong
+	ControlPing ControlFragmentType = 0x01
+
+	// ControlPong answers a ControlPing
+	ControlPong ControlFragmentType = 0x02
+
+	// ControlCancel tells the receiver to stop reading the current message and drain it,
+	// without necessarily tearing down the underlying connection
+	ControlCancel ControlFragmentType = 0x03
+
+	// ControlFlowUpdate grants additional in-flight credit to the peer, pairing with a
+	// pipelined multiPartWriter's CreditOnPeerAck policy
+	ControlFlowUpdate ControlFragmentType = 0x04
 )
 
+// A ControlFragment is a short, non-fragmentable control message multiplexed onto an open
+// message's fragment stream - modelled on WebSocket control frames, which can interleave
+// with a data stream without terminating it. A ControlFragment carries zero chunks and its
+// own independent checksum over Data, rather than participating in the chunked part
+// framing data fragments use; multiPartReader.Read transparently consumes and dispatches
+// any it encounters without exposing them to the caller reading the argument stream. A
+// malformed control fragment (bad flags, unregistered checksum type, checksum mismatch)
+// fails the call the same way a malformed data fragment does today.
+type ControlFragment struct {
+	Type ControlFragmentType
+	Data []byte
+}
+
+// A ControlHandler is notified when a connection receives an inbound ControlFragment
+type ControlHandler interface {
+	HandleControl(ctrl ControlFragment)
+}
+
+// isControlFragment peeks at frame's flags byte to tell whether it carries a
+// ControlFragment rather than a regular data fragment, without otherwise parsing it
+func isControlFragment(frame *Frame) bool {
+	if frame.Header.Size == 0 {
+		return false
+	}
+	return frame.Payload[0]&flagControlFragment != 0
+}
+
+// newOutboundControlFrame renders ctrl into frame as a standalone control fragment
+// multiplexed onto msgType/id - the same message the peer is already receiving data
+// fragments for
+func newOutboundControlFrame(frame *Frame, id uint32, msgType MessageType, ctrl ControlFragment,
+	checksum Checksum, registry *ChecksumRegistry) (*Frame, error) {
+
+	frame.Header.Id = id
+	frame.Header.Type = msgType
+
+	wbuf := typed.NewWriteBuffer(frame.Payload[:])
+	if err := wbuf.WriteByte(flagControlFragment); err != nil {
+		return nil, err
+	}
+	if err := wbuf.WriteByte(byte(ctrl.Type)); err != nil {
+		return nil, err
+	}
+
+	pos := wbuf.CurrentPos()
+	binary.BigEndian.PutUint16(frame.Payload[pos:], uint16(len(ctrl.Data)))
+	pos += 2
+
+	copy(frame.Payload[pos:], ctrl.Data)
+	pos += len(ctrl.Data)
+
+	frame.Payload[pos] = byte(checksum.TypeCode())
+	pos++
+
+	checksum.Add(ctrl.Data)
+
+	checksumSize, ok := registry.Size(checksum.TypeCode())
+	if !ok {
+		checksumSize = checksum.TypeCode().ChecksumSize()
+	}
+	copy(frame.Payload[pos:], checksum.Sum())
+	pos += checksumSize
+
+	frame.Header.Size = uint16(pos)
+	return frame, nil
+}
+
+// newInboundControlFragment decodes frame as a ControlFragment, returning
+// errNotControlFragment if frame's flags don't mark it as one
+func newInboundControlFragment(frame *Frame, registry *ChecksumRegistry) (ControlFragment, error) {
+	payload := frame.Payload[:frame.Header.Size]
+	rbuf := typed.NewReadBuffer(payload)
+
+	flags, err := rbuf.ReadByte()
+	if err != nil {
+		return ControlFragment{}, err
+	}
+	if flags&flagControlFragment == 0 {
+		return ControlFragment{}, errNotControlFragment
+	}
+
+	typeByte, err := rbuf.ReadByte()
+	if err != nil {
+		return ControlFragment{}, err
+	}
+
+	dataLen, err := rbuf.ReadUint16()
+	if err != nil {
+		return ControlFragment{}, err
+	}
+
+	data, err := rbuf.ReadBytes(int(dataLen))
+	if err != nil {
+		return ControlFragment{}, err
+	}
+
+	checksumTypeByte, err := rbuf.ReadByte()
+	if err != nil {
+		return ControlFragment{}, err
+	}
+
+	checksum, err := registry.New(ChecksumType(checksumTypeByte))
+	if err != nil {
+		return ControlFragment{}, err
+	}
+
+	checksumSize, ok := registry.Size(checksum.TypeCode())
+	if !ok {
+		checksumSize = checksum.TypeCode().ChecksumSize()
+	}
+
+	peerChecksum, err := rbuf.ReadBytes(checksumSize)
+	if err != nil {
+		return ControlFragment{}, err
+	}
+
+	checksum.Add(data)
+	if bytes.Compare(peerChecksum, checksum.Sum()) != 0 {
+		return ControlFragment{}, ErrMismatchedChecksum
+	}
+
+	return ControlFragment{Type: ControlFragmentType(typeByte), Data: data}, nil
+}
+
 // An outbound fragment is a fragment being sent to a peer
 type outFragment struct {
 	frame         *Frame
@@ -41,6 +194,12 @@ type outFragment struct {
 	chunkStart    []byte
 	chunkSize     int
 	remaining     []byte
+
+	// seq is the position of this fragment within its message, assigned when the
+	// multiPartWriter acquires the fragment (via beginFragmentAsync when pipelining).
+	// It lets a pipelined multiPartWriter construct several
+	// fragments concurrently while still delivering them to the peer in order
+	seq uint32
 }
 
 // Returns the number of bytes remaining in the fragment
@@ -83,6 +242,64 @@ func (f *outFragment) writeChunkData(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// writeChunkVectored writes data for a chunked part assembled from several caller-owned
+// buffers, as if they had been concatenated first.  Unlike writeChunkData, callers don't
+// need to pre-concatenate bufs into a single buffer before handing it to the fragment;
+// writeChunkVectored copies each buf into the fragment and updates the running checksum
+// as it goes, since Checksum.Add is already incremental over arbitrary slices - so no
+// separate non-incremental fallback is needed here.  All of bufs must fit into the
+// fragment currently being filled; spanning a vectored write across fragments is the
+// caller's responsibility, same as with writeChunkData.
+//
+// This copy is unavoidable here: a fragment's wire bytes live in one contiguous []byte
+// (the frame's pooled Payload buffer), so bufs have to land inside it before the frame can
+// be sent as-is. The actual kernel-level vectored write this series was after happens one
+// layer down, in writeFrames, which hands the frame header and payload to the socket
+// together via net.Buffers instead of as two separate Write calls.
+func (f *outFragment) writeChunkVectored(bufs ...[]byte) (int, error) {
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+
+	if total > len(f.remaining) {
+		return 0, errTooLarge
+	}
+
+	if len(f.chunkStart) == 0 {
+		return 0, errNoOpenChunk
+	}
+
+	written := 0
+	for _, b := range bufs {
+		copy(f.remaining, b)
+		f.remaining = f.remaining[len(b):]
+		f.checksum.Add(b)
+		written += len(b)
+	}
+
+	f.chunkSize += written
+	return written, nil
+}
+
+// commitChunkData records that n bytes, already placed directly into f.remaining by the
+// caller (e.g. via io.ReadFull from a multiPartWriter.ReadFrom source), belong to the open
+// chunk - updating the running checksum and advancing past them, the same bookkeeping
+// writeChunkData does after its copy. Unlike writeChunkData, no copy happens here: the
+// caller is expected to have read straight into f.remaining, which is the whole point of
+// driving a ReaderFrom off of it.
+func (f *outFragment) commitChunkData(n int) error {
+	if len(f.chunkStart) == 0 {
+		return errNoOpenChunk
+	}
+
+	b := f.remaining[:n]
+	f.remaining = f.remaining[n:]
+	f.chunkSize += n
+	f.checksum.Add(b)
+	return nil
+}
+
 // Returns true if the fragment can fit a new chunk
 func (f *outFragment) canFitNewChunk() bool {
 	return len(f.remaining) > 2
@@ -115,8 +332,12 @@ func (f *outFragment) endChunk() error {
 // Returns true if the fragment has a chunk open
 func (f *outFragment) chunkOpen() bool { return len(f.chunkStart) > 0 }
 
-// Creates a new outFragment around a frame and message, with a running checksum
-func newOutboundFragment(frame *Frame, msg Message, checksum Checksum) (*outFragment, error) {
+// Creates a new outFragment around a frame and message, with a running checksum. registry
+// is consulted for the wire size of checksum's type, falling back to
+// ChecksumType.ChecksumSize if the type isn't registered in it (e.g. a custom registry
+// that leaves out the long-standing CRC32 baseline, which DefaultChecksumRegistry does
+// register).
+func newOutboundFragment(frame *Frame, msg Message, checksum Checksum, registry *ChecksumRegistry) (*outFragment, error) {
 	f := &outFragment{
 		frame:    frame,
 		checksum: checksum,
@@ -141,11 +362,16 @@ func newOutboundFragment(frame *Frame, msg Message, checksum Checksum) (*outFrag
 		return nil, err
 	}
 
+	checksumSize, ok := registry.Size(f.checksum.TypeCode())
+	if !ok {
+		checksumSize = f.checksum.TypeCode().ChecksumSize()
+	}
+
 	f.remaining = f.frame.Payload[wbuf.CurrentPos():]
-	f.checksumBytes = f.remaining[:f.checksum.TypeCode().ChecksumSize()]
+	f.checksumBytes = f.remaining[:checksumSize]
 
 	// Everything remaining is available for content
-	f.remaining = f.remaining[f.checksum.TypeCode().ChecksumSize():]
+	f.remaining = f.remaining[checksumSize:]
 	return f, nil
 }
 
@@ -158,6 +384,176 @@ type outFragmentChannel interface {
 
 	// Ends the currently open fragment, optionally marking it as the last fragment
 	flushFragment(f *outFragment, last bool) error
+
+	// sendControl sends ctrl to the peer, multiplexed onto this channel's open message
+	// without disturbing any fragment currently under construction
+	sendControl(ctrl ControlFragment) error
+}
+
+// asyncOutFragmentChannel is implemented by outFragmentChannels that can construct and
+// deliver several fragments of the same message concurrently.  A multiPartWriter created
+// with newPipelinedMultiPartWriter uses beginFragmentAsync in place of beginFragment so
+// that each fragment it hands out carries the sequence number needed to restore wire
+// order, and uses deliverFrame in place of flushFragment so that a fragment can be handed
+// to the transport from whichever goroutine happens to finish constructing it.
+type asyncOutFragmentChannel interface {
+	outFragmentChannel
+
+	// Opens a new fragment tagged with seq, so fragments for the same message can be
+	// built concurrently and later reassembled in order
+	beginFragmentAsync(seq uint32) (*outFragment, error)
+
+	// Hands a fully constructed frame to the transport.  Unlike flushFragment, the frame
+	// has already been finished (checksum and flags set); deliverFrame only has to send it
+	deliverFrame(frame *Frame) error
+}
+
+// CreditPolicy controls when a pipelined multiPartWriter returns an in-flight fragment's
+// credit, allowing a new fragment to begin construction
+type CreditPolicy int
+
+const (
+	// CreditOnSocketWrite returns a fragment's credit as soon as it has been handed to
+	// the connection's send channel, without waiting for any acknowledgement from the peer
+	CreditOnSocketWrite CreditPolicy = iota
+
+	// CreditOnPeerAck returns a fragment's credit only once the peer has acknowledged
+	// receiving it.  NOTE: the wire protocol has no fragment-ack message today, so this
+	// currently behaves identically to CreditOnSocketWrite; it is reserved for when one
+	// is added
+	CreditOnPeerAck
+)
+
+// WriterOptions controls how a multiPartWriter constructs and delivers fragments.  The
+// zero value is equivalent to the writer's default serial behavior: one fragment
+// constructed and flushed at a time.
+type WriterOptions struct {
+	// MaxInFlightFragments is the maximum number of fragments that may be under
+	// construction or in flight to the peer at once.  Values less than 2 disable
+	// pipelining entirely.
+	MaxInFlightFragments int
+
+	// CreditPolicy determines when an in-flight fragment's credit is returned
+	CreditPolicy CreditPolicy
+}
+
+// DefaultWriterOptions is the WriterOptions used by newMultiPartWriter: fragments are
+// constructed and flushed one at a time, with no pipelining
+var DefaultWriterOptions = WriterOptions{MaxInFlightFragments: 1, CreditPolicy: CreditOnSocketWrite}
+
+// FragmentBufferPool supplies reusable scratch buffers sized for a connection's
+// negotiated fragment size, so a multiPartWriter assembling a vectored write that
+// doesn't fit the current fragment can merge it into one buffer without a fresh
+// allocation per write.
+type FragmentBufferPool interface {
+	// Get returns a buffer of at least size bytes.  The returned slice's length is size.
+	Get(size int) []byte
+
+	// Put returns a buffer previously obtained from Get for reuse.  Callers must not
+	// use buf after calling Put.
+	Put(buf []byte)
+}
+
+// syncFragmentBufferPool is a FragmentBufferPool backed by a sync.Pool of buffers sized
+// to the fragment size a connection negotiated during the init handshake
+type syncFragmentBufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewFragmentBufferPool creates a FragmentBufferPool whose buffers are sized to
+// fragmentSize.  Get requests for a larger size bypass the pool and allocate directly,
+// since they can't have come from a connection negotiated at fragmentSize.
+func NewFragmentBufferPool(fragmentSize int) FragmentBufferPool {
+	p := &syncFragmentBufferPool{size: fragmentSize}
+	p.pool.New = func() interface{} {
+		return make([]byte, p.size)
+	}
+	return p
+}
+
+func (p *syncFragmentBufferPool) Get(size int) []byte {
+	if size > p.size {
+		return make([]byte, size)
+	}
+
+	return p.pool.Get().([]byte)[:size]
+}
+
+func (p *syncFragmentBufferPool) Put(buf []byte) {
+	if cap(buf) < p.size {
+		return
+	}
+
+	p.pool.Put(buf[:cap(buf)])
+}
+
+// defaultFragmentSize is the fragment payload size DefaultFragmentBufferPool's buffers
+// are sized for, matching the typical negotiated frame size
+const defaultFragmentSize = 64 << 10 // 64 KiB
+
+// DefaultFragmentBufferPool is used by connections that don't supply their own
+// FragmentBufferPool via TChannelConnectionOptions
+var DefaultFragmentBufferPool = NewFragmentBufferPool(defaultFragmentSize)
+
+// reorderBuffer restores wire order among fragments whose construction and delivery may
+// complete out of order, because a pipelined multiPartWriter hands each fragment's
+// delivery off to its own goroutine.  Fragments are released to emit in sequence order;
+// ones that arrive ahead of a still-pending predecessor are buffered until that
+// predecessor has been delivered.
+type reorderBuffer struct {
+	mut     sync.Mutex
+	next    uint32
+	pending map[uint32]reorderEntry
+	emit    func(seq uint32, frame *Frame) error
+	err     error
+}
+
+type reorderEntry struct {
+	frame *Frame
+	done  func()
+}
+
+// Creates a new reorderBuffer that calls emit, in seq order, for each frame submitted
+func newReorderBuffer(emit func(seq uint32, frame *Frame) error) *reorderBuffer {
+	return &reorderBuffer{pending: make(map[uint32]reorderEntry), emit: emit}
+}
+
+// submit delivers frame once all fragments preceding seq have themselves been delivered.
+// done is called at the point frame is actually handed to emit - which may happen on a
+// different goroutine than the one calling submit, if frame arrived ahead of a
+// predecessor that is still being constructed.  Callers use done to release a fragment's
+// in-flight credit only once it has actually reached the wire.  Once an emit has failed,
+// submit calls done immediately and drops frame without emitting it; frames that were
+// still buffered behind the failed one are never emitted and their done is never called.
+func (b *reorderBuffer) submit(seq uint32, frame *Frame, done func()) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.err != nil {
+		done()
+		return b.err
+	}
+
+	b.pending[seq] = reorderEntry{frame: frame, done: done}
+	for {
+		entry, ok := b.pending[b.next]
+		if !ok {
+			break
+		}
+
+		delete(b.pending, b.next)
+		b.next++
+
+		err := b.emit(b.next-1, entry.frame)
+		entry.done()
+		if err != nil {
+			b.err = err
+			return err
+		}
+	}
+
+	return nil
 }
 
 // An multiPartWriter is an io.Writer for a collection of parts, capable of breaking
@@ -169,6 +565,110 @@ type multiPartWriter struct {
 	fragment    *outFragment
 	alignsAtEnd bool
 	complete    bool
+
+	// nextSeq, credits and reorder are only set when pipelining is enabled; credits ==
+	// nil means fragments are constructed and flushed serially, exactly as before
+	// pipelining was introduced
+	nextSeq uint32
+	credits chan struct{}
+	reorder *reorderBuffer
+	wg      sync.WaitGroup
+
+	mut     sync.Mutex
+	failure error
+
+	// lastDispatchedSeq/haveDispatchedSeq track the highest fragment sequence handed to
+	// the transport, for Checkpoint.  Guarded by mut.
+	lastDispatchedSeq uint32
+	haveDispatchedSeq bool
+
+	// bufferPool supplies scratch buffers for WriteVectored's merge fallback; nil means
+	// WriteVectored falls back to a fresh allocation instead
+	bufferPool FragmentBufferPool
+}
+
+// checksumSource is implemented by outFragmentChannels that expose the single Checksum
+// their fragments accumulate into across the whole message, which Checkpoint needs in
+// order to snapshot its state
+type checksumSource interface {
+	currentChecksum() Checksum
+}
+
+// ErrNotResumable is returned by Checkpoint when the writer's channel or checksum doesn't
+// support snapshotting, and by ResumeMultiPartWriter when ch or cp doesn't either
+var ErrNotResumable = errors.New("multiPartWriter: channel or checksum does not support checkpointing")
+
+// StreamCheckpoint captures enough state to resume a multiPartWriter's output on a fresh
+// outFragmentChannel after a reconnect.  Seq is the highest fragment sequence this writer
+// had handed to the transport when Checkpoint was called - NOT a peer-acknowledged
+// sequence, since the wire protocol has no fragment-ack message today (see
+// CreditOnPeerAck); a resumed writer may therefore retransmit a fragment the peer already
+// received. ChecksumState is the snapshot of the running checksum as of that same point.
+type StreamCheckpoint struct {
+	Seq           uint32
+	HasSeq        bool
+	ChecksumType  ChecksumType
+	ChecksumState []byte
+}
+
+// Checkpoint captures enough state to resume this writer's output against a fresh
+// outFragmentChannel via ResumeMultiPartWriter, provided the channel exposes its checksum
+// via checksumSource and that checksum implements SnapshotableChecksum.
+func (w *multiPartWriter) Checkpoint() (StreamCheckpoint, error) {
+	src, ok := w.fragments.(checksumSource)
+	if !ok {
+		return StreamCheckpoint{}, ErrNotResumable
+	}
+
+	snap, ok := src.currentChecksum().(SnapshotableChecksum)
+	if !ok {
+		return StreamCheckpoint{}, ErrNotResumable
+	}
+
+	state, err := snap.MarshalState()
+	if err != nil {
+		return StreamCheckpoint{}, err
+	}
+
+	w.mut.Lock()
+	seq, hasSeq := w.lastDispatchedSeq, w.haveDispatchedSeq
+	w.mut.Unlock()
+
+	return StreamCheckpoint{
+		Seq:           seq,
+		HasSeq:        hasSeq,
+		ChecksumType:  snap.TypeCode(),
+		ChecksumState: state,
+	}, nil
+}
+
+// ResumeMultiPartWriter rebuilds a serial multiPartWriter against ch - typically a fresh
+// outFragmentChannel for a reconnected socket - positioned so the next Write produces the
+// fragment after cp.Seq, with ch's checksum restored to the accumulated state captured in
+// cp.  ch's checksum must implement SnapshotableChecksum and match cp.ChecksumType, or this
+// returns ErrNotResumable.
+func ResumeMultiPartWriter(ch outFragmentChannel, cp StreamCheckpoint) (*multiPartWriter, error) {
+	src, ok := ch.(checksumSource)
+	if !ok {
+		return nil, ErrNotResumable
+	}
+
+	snap, ok := src.currentChecksum().(SnapshotableChecksum)
+	if !ok || snap.TypeCode() != cp.ChecksumType {
+		return nil, ErrNotResumable
+	}
+
+	if err := snap.UnmarshalState(cp.ChecksumState); err != nil {
+		return nil, err
+	}
+
+	w := newMultiPartWriter(ch)
+	if cp.HasSeq {
+		w.lastDispatchedSeq = cp.Seq
+		w.haveDispatchedSeq = true
+		w.nextSeq = cp.Seq + 1
+	}
+	return w, nil
 }
 
 // Creates a new multiPartWriter that creates and sends fragments through the provided channel.
@@ -176,6 +676,33 @@ func newMultiPartWriter(ch outFragmentChannel) *multiPartWriter {
 	return &multiPartWriter{fragments: ch}
 }
 
+// setBufferPool records the pool WriteVectored uses for its merge-buffer fallback
+func (w *multiPartWriter) setBufferPool(pool FragmentBufferPool) {
+	w.bufferPool = pool
+}
+
+// newPipelinedMultiPartWriter creates a multiPartWriter that may construct and deliver up
+// to opts.MaxInFlightFragments fragments concurrently, so a slow send of one fragment
+// doesn't stall construction of the next.  ch must implement asyncOutFragmentChannel; if
+// it doesn't, or opts doesn't ask for pipelining, the writer falls back to the same
+// serial behavior as newMultiPartWriter.
+func newPipelinedMultiPartWriter(ch outFragmentChannel, opts WriterOptions) *multiPartWriter {
+	async, ok := ch.(asyncOutFragmentChannel)
+	if !ok || opts.MaxInFlightFragments < 2 {
+		return newMultiPartWriter(ch)
+	}
+
+	w := &multiPartWriter{fragments: ch}
+	w.credits = make(chan struct{}, opts.MaxInFlightFragments)
+	for i := 0; i < opts.MaxInFlightFragments; i++ {
+		w.credits <- struct{}{}
+	}
+	w.reorder = newReorderBuffer(func(seq uint32, frame *Frame) error {
+		return async.deliverFrame(frame)
+	})
+	return w
+}
+
 // Writes an entire part
 func (w *multiPartWriter) WritePart(output Output, last bool) error {
 	if err := output.WriteTo(w); err != nil {
@@ -234,13 +761,150 @@ func (w *multiPartWriter) Write(b []byte) (int, error) {
 	return written, nil
 }
 
+// ReadFrom implements io.ReaderFrom, letting callers that already hold an io.Reader - an
+// *os.File, a *bytes.Reader, a net.Conn - drive Write's per-fragment chunking without
+// first copying r's bytes into a caller-owned buffer: each fragment's free space is read
+// into directly via io.ReadFull, and the running checksum is computed over that same
+// slice via commitChunkData. r is read until it returns an error; io.EOF is the expected
+// terminal case and is not returned to the caller, matching the io.ReaderFrom contract.
+func (w *multiPartWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.complete {
+		return 0, ErrWriteAfterComplete
+	}
+
+	var total int64
+	for {
+		if w.fragment == nil {
+			// Acquiring a fragment isn't free - under pipelining it can block on a
+			// send credit, and it always allocates wire-format state - so confirm
+			// there is at least one more byte to write before paying for one.
+			// Without this probe, r running out exactly on a fragment boundary would
+			// leave a freshly opened, empty fragment behind with w.alignsAtEnd still
+			// set from the fragment just finished, which endPart treats as an impl
+			// error (w.alignsAtEnd true with a non-nil w.fragment should never
+			// happen - alignsAtEnd means the next fragment, if any, hasn't been
+			// opened yet).
+			var probe [1]byte
+			n, err := io.ReadFull(r, probe[:])
+			if n == 0 {
+				if err == io.EOF {
+					return total, nil
+				}
+				return total, err
+			}
+
+			if err := w.ensureOpenChunk(); err != nil {
+				return total, err
+			}
+			if cerr := w.fragment.commitChunkData(copy(w.fragment.remaining, probe[:n])); cerr != nil {
+				return total, cerr
+			}
+			total += int64(n)
+			w.alignsAtEnd = w.fragment.bytesRemaining() == 0
+
+			if w.alignsAtEnd {
+				if ferr := w.finishFragment(false); ferr != nil {
+					return total, ferr
+				}
+			}
+			continue
+		}
+
+		if err := w.ensureOpenChunk(); err != nil {
+			return total, err
+		}
+
+		want := w.fragment.remaining
+		n, err := io.ReadFull(r, want)
+		if n > 0 {
+			if cerr := w.fragment.commitChunkData(n); cerr != nil {
+				return total, cerr
+			}
+			total += int64(n)
+			w.alignsAtEnd = w.fragment.bytesRemaining() == 0
+		}
+
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				if w.fragment.bytesRemaining() == 0 {
+					if ferr := w.finishFragment(false); ferr != nil {
+						return total, ferr
+					}
+				}
+				return total, nil
+			}
+			return total, err
+		}
+
+		// Filled this fragment exactly and r still has more to give - finish it and
+		// loop back to probe for more before paying for a new one
+		if err := w.finishFragment(false); err != nil {
+			return total, err
+		}
+	}
+}
+
+// WriteVectored writes a single logical chunk of part data assembled from several
+// caller-owned buffers - e.g. a streamed arg3's header and body kept as separate slices -
+// without requiring the caller to concatenate them first. When bufs fit entirely within
+// the fragment currently being filled, they're copied straight into it via
+// writeChunkVectored, saving the allocation and copy a caller would otherwise need to
+// build one contiguous []byte. When they don't fit, WriteVectored merges them into a
+// scratch buffer (drawn from bufferPool if one is set) and falls back to the regular
+// Write path, which already knows how to split a part across fragment boundaries.
+func (w *multiPartWriter) WriteVectored(bufs ...[]byte) (int, error) {
+	if w.complete {
+		return 0, ErrWriteAfterComplete
+	}
+
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+
+	if err := w.ensureOpenChunk(); err != nil {
+		return 0, err
+	}
+
+	if total > 0 && total <= w.fragment.bytesRemaining() {
+		n, err := w.fragment.writeChunkVectored(bufs...)
+		if err != nil {
+			return n, err
+		}
+
+		w.alignsAtEnd = w.fragment.bytesRemaining() == 0
+		if w.alignsAtEnd {
+			if err := w.finishFragment(false); err != nil {
+				return n, err
+			}
+		}
+
+		return n, nil
+	}
+
+	var merged []byte
+	if w.bufferPool != nil {
+		merged = w.bufferPool.Get(total)
+		defer w.bufferPool.Put(merged)
+	} else {
+		merged = make([]byte, total)
+	}
+
+	pos := 0
+	for _, b := range bufs {
+		pos += copy(merged[pos:], b)
+	}
+
+	return w.Write(merged)
+}
+
 // Ensures that we have a fragment and an open chunk
 func (w *multiPartWriter) ensureOpenChunk() error {
 	for {
 		// No fragment - start a new one
 		if w.fragment == nil {
 			var err error
-			if w.fragment, err = w.fragments.beginFragment(); err != nil {
+			if w.fragment, err = w.acquireFragment(); err != nil {
 				return err
 			}
 		}
@@ -263,16 +927,105 @@ func (w *multiPartWriter) ensureOpenChunk() error {
 	}
 }
 
+// acquireFragment opens a new fragment, blocking until a credit is available if
+// pipelining is enabled and MaxInFlightFragments fragments are already under
+// construction or in flight
+func (w *multiPartWriter) acquireFragment() (*outFragment, error) {
+	if w.credits == nil {
+		f, err := w.fragments.beginFragment()
+		if err != nil {
+			return nil, err
+		}
+		f.seq = w.nextSeq
+		w.nextSeq++
+		return f, nil
+	}
+
+	if err := w.pipelineErr(); err != nil {
+		return nil, err
+	}
+
+	<-w.credits
+	seq := w.nextSeq
+	w.nextSeq++
+
+	f, err := w.fragments.(asyncOutFragmentChannel).beginFragmentAsync(seq)
+	if err != nil {
+		w.credits <- struct{}{}
+		return nil, err
+	}
+
+	return f, nil
+}
+
 // Finishes with the current fragment, closing any open chunk and sending the fragment down the channel
 func (w *multiPartWriter) finishFragment(last bool) error {
 	w.fragment.endChunk()
-	if err := w.fragments.flushFragment(w.fragment, last); err != nil {
-		w.fragment = nil
-		return err
+	f := w.fragment
+	w.fragment = nil
+	return w.dispatchFragment(f, last)
+}
+
+// dispatchFragment delivers f to the peer.  With pipelining disabled this flushes f
+// synchronously, exactly as before pipelining was introduced.  With pipelining enabled,
+// f is handed off to its own goroutine via the writer's reorderBuffer once finished, so
+// construction of the next fragment isn't blocked on this one reaching the wire.
+func (w *multiPartWriter) dispatchFragment(f *outFragment, last bool) error {
+	if w.credits == nil {
+		if err := w.fragments.flushFragment(f, last); err != nil {
+			return err
+		}
+		w.recordDispatched(f.seq)
+		return nil
 	}
 
-	w.fragment = nil
-	return nil
+	// f.finish reads f.checksum.Sum(), and f.checksum is the single Checksum instance
+	// this whole response accumulates into across every fragment (see beginFragment) -
+	// not a per-fragment copy. The caller is about to go straight on to acquiring the
+	// next fragment and calling Add() on that same instance, so finish must happen here,
+	// synchronously, before that can start: deferring it into the goroutine below would
+	// race Sum() against the next fragment's Add() calls, and - win or lose the race -
+	// corrupt which bytes this fragment's checksum actually ends up covering.
+	frame := f.finish(last)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		if err := w.reorder.submit(f.seq, frame, func() { w.credits <- struct{}{} }); err != nil {
+			w.setPipelineErr(err)
+			return
+		}
+		w.recordDispatched(f.seq)
+	}()
+
+	return w.pipelineErr()
+}
+
+// recordDispatched notes that fragment seq has been handed to the transport, for later
+// use by Checkpoint.  Fragments can be dispatched out of order under pipelining, so this
+// only ever moves lastDispatchedSeq forward.
+func (w *multiPartWriter) recordDispatched(seq uint32) {
+	w.mut.Lock()
+	if !w.haveDispatchedSeq || seq > w.lastDispatchedSeq {
+		w.lastDispatchedSeq = seq
+		w.haveDispatchedSeq = true
+	}
+	w.mut.Unlock()
+}
+
+func (w *multiPartWriter) pipelineErr() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.failure
+}
+
+func (w *multiPartWriter) setPipelineErr(err error) {
+	w.mut.Lock()
+	if w.failure == nil {
+		w.failure = err
+	}
+	w.mut.Unlock()
 }
 
 // Marks the part as being complete.  If last is true, this is the last part in the message
@@ -285,7 +1038,7 @@ func (w *multiPartWriter) endPart(last bool) error {
 		}
 
 		var err error
-		w.fragment, err = w.fragments.beginFragment()
+		w.fragment, err = w.acquireFragment()
 		if err != nil {
 			return err
 		}
@@ -298,7 +1051,16 @@ func (w *multiPartWriter) endPart(last bool) error {
 	}
 
 	if last {
-		if err := w.fragments.flushFragment(w.fragment, true); err != nil {
+		f := w.fragment
+		w.fragment = nil
+		if err := w.dispatchFragment(f, true); err != nil {
+			return err
+		}
+
+		// Wait for any fragments still in flight to actually reach the peer before
+		// declaring the part - and the call - complete
+		w.wg.Wait()
+		if err := w.pipelineErr(); err != nil {
 			return err
 		}
 
@@ -316,8 +1078,16 @@ type inFragment struct {
 	chunks   [][]byte // The part chunks contained in the fragment
 }
 
-// Creates a new inFragment from an incoming frame and an expected message
-func newInboundFragment(frame *Frame, msg Message, checksum Checksum) (*inFragment, error) {
+// Creates a new inFragment from an incoming frame and an expected message. checksum is
+// the checksum established by the message's first fragment, or nil if frame is that first
+// fragment. registry resolves the checksum type code read off the wire: if checksum is
+// nil, registry constructs a fresh Checksum for whatever type the peer sent; if checksum
+// is non-nil and the peer's type differs, the switch is only honored when registry flags
+// both the old and new types as negotiable, otherwise it's ErrMismatchedChecksumTypes as
+// before. A negotiated switch restarts checksum accumulation from this fragment forward -
+// there's no way to carry a running CRC32 sum over into an xxhash accumulator, so this is
+// a deliberate simplification rather than a true cross-algorithm continuation.
+func newInboundFragment(frame *Frame, msg Message, checksum Checksum, registry *ChecksumRegistry) (*inFragment, error) {
 	f := &inFragment{
 		frame:    frame,
 		checksum: checksum,
@@ -345,13 +1115,31 @@ func newInboundFragment(frame *Frame, msg Message, checksum Checksum) (*inFragme
 		return nil, err
 	}
 
+	wireType := ChecksumType(checksumType)
 	if f.checksum == nil {
-		f.checksum = ChecksumType(checksumType).New()
-	} else if ChecksumType(checksumType) != checksum.TypeCode() {
-		return nil, ErrMismatchedChecksumTypes
+		c, err := registry.New(wireType)
+		if err != nil {
+			return nil, err
+		}
+		f.checksum = c
+	} else if wireType != checksum.TypeCode() {
+		if !registry.IsNegotiable(checksum.TypeCode()) || !registry.IsNegotiable(wireType) {
+			return nil, ErrMismatchedChecksumTypes
+		}
+
+		c, err := registry.New(wireType)
+		if err != nil {
+			return nil, err
+		}
+		f.checksum = c
+	}
+
+	checksumSize, ok := registry.Size(f.checksum.TypeCode())
+	if !ok {
+		checksumSize = f.checksum.TypeCode().ChecksumSize()
 	}
 
-	peerChecksum, err := rbuf.ReadBytes(f.checksum.TypeCode().ChecksumSize())
+	peerChecksum, err := rbuf.ReadBytes(checksumSize)
 	if err != nil {
 		return nil, err
 	}
@@ -451,6 +1239,41 @@ func (r *multiPartReader) Read(b []byte) (int, error) {
 	return totalRead, nil
 }
 
+// WriteTo implements io.WriterTo, letting io.Copy(dst, r) hand each chunk straight to
+// dst.Write instead of bouncing it through a caller-supplied buffer the way Read requires.
+// Reading continues until the part is exhausted; io.EOF from waitForFragment is the
+// expected terminal case and is not returned to the caller, matching the io.WriterTo
+// contract.
+func (r *multiPartReader) WriteTo(dst io.Writer) (int64, error) {
+	var total int64
+
+	for {
+		if len(r.chunk) == 0 {
+			if r.lastChunkInFragment {
+				return total, nil
+			}
+
+			nextFragment, err := r.fragments.waitForFragment()
+			if err != nil {
+				if err == io.EOF {
+					return total, nil
+				}
+				return total, err
+			}
+
+			r.chunk = nextFragment.nextChunk()
+			r.lastChunkInFragment = nextFragment.hasMoreChunks()
+		}
+
+		n, err := dst.Write(r.chunk)
+		total += int64(n)
+		r.chunk = r.chunk[n:]
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
 // Marks the current part as complete, confirming that we've read the entire part and have nothing left over
 func (r *multiPartReader) endPart() error {
 	if len(r.chunk) > 0 {
@@ -0,0 +1,99 @@
+package tchannel
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newTestOutFragment builds a bare outFragment with an open chunk and no attached Frame,
+// since writeChunkVectored/writeChunkData never touch f.frame - only f.remaining,
+// f.chunkStart, f.chunkSize and f.checksum.
+func newTestOutFragment(remaining int) *outFragment {
+	return &outFragment{
+		checksum:   newCrc32cChecksum(),
+		remaining:  make([]byte, remaining),
+		chunkStart: make([]byte, 1),
+	}
+}
+
+func TestOutFragmentWriteChunkVectored(t *testing.T) {
+	f := newTestOutFragment(32)
+	a, b, c := []byte("foo"), []byte("bar"), []byte("bazqux")
+
+	n, err := f.writeChunkVectored(a, b, c)
+	if err != nil {
+		t.Fatalf("writeChunkVectored: %v", err)
+	}
+	want := len(a) + len(b) + len(c)
+	if n != want {
+		t.Fatalf("n = %d; want %d", n, want)
+	}
+	if f.chunkSize != want {
+		t.Fatalf("chunkSize = %d; want %d", f.chunkSize, want)
+	}
+	if len(f.remaining) != 32-want {
+		t.Fatalf("remaining = %d; want %d", len(f.remaining), 32-want)
+	}
+
+	wantChecksum := newCrc32cChecksum()
+	wantChecksum.Add(append(append(append([]byte{}, a...), b...), c...))
+	gotChecksum := f.checksum.Sum()
+	if !bytes.Equal(gotChecksum, wantChecksum.Sum()) {
+		t.Fatalf("checksum = %x; want %x", gotChecksum, wantChecksum.Sum())
+	}
+}
+
+func TestOutFragmentWriteChunkVectoredTooLarge(t *testing.T) {
+	f := newTestOutFragment(4)
+	if _, err := f.writeChunkVectored([]byte("foo"), []byte("bar")); err != errTooLarge {
+		t.Fatalf("err = %v; want errTooLarge", err)
+	}
+}
+
+func TestOutFragmentWriteChunkVectoredNoOpenChunk(t *testing.T) {
+	f := newTestOutFragment(32)
+	f.chunkStart = nil
+	if _, err := f.writeChunkVectored([]byte("foo")); err != errNoOpenChunk {
+		t.Fatalf("err = %v; want errNoOpenChunk", err)
+	}
+}
+
+// BenchmarkOutFragmentWriteChunkVectored reports the allocations/op of writing a
+// multi-buffer chunk via writeChunkVectored, the path WriteVectored takes when the
+// buffers fit entirely within the fragment currently being filled.
+func BenchmarkOutFragmentWriteChunkVectored(b *testing.B) {
+	bufs := [][]byte{
+		bytes.Repeat([]byte{1}, 256),
+		bytes.Repeat([]byte{2}, 256),
+		bytes.Repeat([]byte{3}, 512),
+	}
+	total := 0
+	for _, buf := range bufs {
+		total += len(buf)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := newTestOutFragment(total)
+		if _, err := f.writeChunkVectored(bufs...); err != nil {
+			b.Fatalf("writeChunkVectored: %v", err)
+		}
+	}
+}
+
+func TestFragmentBufferPoolGetPut(t *testing.T) {
+	pool := NewFragmentBufferPool(1024)
+
+	buf := pool.Get(512)
+	if len(buf) != 512 {
+		t.Fatalf("len(buf) = %d; want 512", len(buf))
+	}
+	pool.Put(buf)
+
+	// A request larger than the pool's configured size bypasses pooling entirely.
+	big := pool.Get(2048)
+	if len(big) != 2048 {
+		t.Fatalf("len(big) = %d; want 2048", len(big))
+	}
+}
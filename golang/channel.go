@@ -23,6 +23,8 @@ package tchannel
 import (
 	"golang.org/x/net/context"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -41,6 +43,18 @@ type HandlerFunc func(ctx context.Context, call *InboundCall)
 // Handle calls f(ctx, call)
 func (f HandlerFunc) Handle(ctx context.Context, call *InboundCall) { f(ctx, call) }
 
+// A ProtocolHandler is notified once a connection has completed the init handshake and
+// both sides have advertised support for the named sub-protocol it was registered under
+type ProtocolHandler interface {
+	HandleInit(conn *TChannelConnection)
+}
+
+// A subProtocol is a named, versioned wire-level extension registered with a TChannel
+type subProtocol struct {
+	versions []uint16
+	handler  ProtocolHandler
+}
+
 // ChannelOptions are used to control parameters on a create a TChannel
 type ChannelOptions struct {
 	// Default Connection options
@@ -51,6 +65,25 @@ type ChannelOptions struct {
 
 	// The logger to use for this channel
 	Logger Logger
+
+	// PeerErrorHandler, if set, is notified whenever a connection to a peer is torn down
+	// after an identified failure (read, write, protocol or version errors), along with the
+	// peer's identity and the reason for the disconnect.  It is not notified when a
+	// connection is closed by Close or by any other plain connection error.
+	PeerErrorHandler PeerErrorHandler
+
+	// NATTraversal, if set, is used to discover an externally reachable address for this
+	// channel's listener (via UPnP IGD, NAT-PMP, or a test fake) and advertise that
+	// address as the channel's HostPort instead of the local bind address.
+	NATTraversal *NATTraversal
+
+	// TraceReporter, if set, is notified of the start and finish of every inbound call's
+	// trace span, for delivery to a distributed tracing backend
+	TraceReporter TraceReporter
+
+	// ChecksumRegistry, if set, overrides DefaultChecksumRegistry as the set of checksum
+	// types this channel can construct and will advertise during the init handshake
+	ChecksumRegistry *ChecksumRegistry
 }
 
 // A TChannel is a bi-directional connection to the peering and routing network.  Applications
@@ -65,6 +98,17 @@ type TChannel struct {
 	connectionOptions ConnectionOptions
 	handlers          handlerMap
 	l                 net.Listener
+	pool              *ConnectionPool
+	protocols         map[string]*subProtocol
+	closing           int32
+	inboundConns      sync.Map
+	peerErrorHandler  PeerErrorHandler
+	nat               *NATTraversal
+	releaseNAT        func()
+	compressors       *compressorRegistry
+	traceReporter     TraceReporter
+	interceptors      []Interceptor
+	checksumRegistry  *ChecksumRegistry
 }
 
 // NewChannel creates a new Channel that will bind to the given host and port.  If no port is provided,
@@ -79,11 +123,30 @@ func NewChannel(hostPort string, opts *ChannelOptions) (*TChannel, error) {
 		logger = NullLogger{}
 	}
 
+	checksumRegistry := opts.ChecksumRegistry
+	if checksumRegistry == nil {
+		checksumRegistry = DefaultChecksumRegistry
+	}
+
 	ch := &TChannel{
 		connectionOptions: opts.DefaultConnectionOptions,
 		processName:       opts.ProcessName,
 		log:               logger,
+		peerErrorHandler:  opts.PeerErrorHandler,
+		traceReporter:     opts.TraceReporter,
+		checksumRegistry:  checksumRegistry,
 	}
+	ch.pool = NewConnectionPool(ch)
+	ch.protocols = make(map[string]*subProtocol)
+	ch.compressors = newCompressorRegistry()
+	ch.compressors.register(gzipCompressor{})
+
+	// RecoveryInterceptor is registered first, making it the outermost layer of the
+	// middleware chain, so it can recover a panic from the handler or from any
+	// interceptor registered after it rather than letting the dispatch goroutine die and
+	// leak its entry in activeReqChs. This is on by default - RegisterInterceptor only
+	// ever adds more layers inside it.
+	ch.interceptors = append(ch.interceptors, RecoveryInterceptor())
 
 	addr, err := net.ResolveTCPAddr("tcp", hostPort)
 	if err != nil {
@@ -99,6 +162,21 @@ func NewChannel(hostPort string, opts *ChannelOptions) (*TChannel, error) {
 
 	ch.l = l
 	ch.hostPort = l.Addr().String()
+
+	if opts.NATTraversal != nil {
+		ch.nat = opts.NATTraversal
+		localPort := l.Addr().(*net.TCPAddr).Port
+		externalHostPort, release, err := ch.nat.applyTo(localPort)
+		if err != nil {
+			ch.log.Errorf("Could not establish NAT mapping for %s: %v", hostPort, err)
+			l.Close()
+			return nil, err
+		}
+
+		ch.hostPort = externalHostPort
+		ch.releaseNAT = release
+	}
+
 	ch.connectionOptions.PeerInfo.HostPort = ch.hostPort
 	ch.connectionOptions.PeerInfo.ProcessName = ch.processName
 	ch.log.Infof("%s listening on %s", ch.processName, ch.hostPort)
@@ -115,26 +193,90 @@ func (ch *TChannel) Register(h Handler, serviceName, operationName string) {
 	ch.handlers.register(h, serviceName, operationName)
 }
 
+// RegisterCompressor registers a Compressor that can be negotiated for arg2/arg3 payloads
+// via the accept-encoding/content-encoding transport headers.  The channel always
+// understands "gzip" out of the box; additional codecs (snappy, lz4, ...) can be plugged
+// in by calling this before the channel starts handling calls.
+func (ch *TChannel) RegisterCompressor(c Compressor) {
+	ch.compressors.register(c)
+}
+
+// RegisterInterceptor adds i to the end of the middleware chain dispatchInbound wraps
+// around every inbound call's handler.  Interceptors run in the order they were
+// registered, outermost first.
+func (ch *TChannel) RegisterInterceptor(i Interceptor) {
+	ch.interceptors = append(ch.interceptors, i)
+}
+
+// RegisterProtocol registers a named sub-protocol, along with the versions of it this
+// process supports, so that it is advertised to peers during the init handshake.  handler
+// is notified once a connection negotiates support for this sub-protocol with the peer.
+func (ch *TChannel) RegisterProtocol(name string, versions []uint16, handler ProtocolHandler) {
+	ch.protocols[name] = &subProtocol{versions: versions, handler: handler}
+}
+
+// advertisedProtocols returns the sub-protocols (and their supported versions) this
+// process advertises during the init handshake
+func (ch *TChannel) advertisedProtocols() map[string][]uint16 {
+	advertised := make(map[string][]uint16, len(ch.protocols))
+	for name, p := range ch.protocols {
+		advertised[name] = p.versions
+	}
+	return advertised
+}
+
+// RegisterChecksumType adds a Checksum implementation to this channel's checksum
+// registry, so applications can add support for e.g. hardware-accelerated CRC32C or
+// xxhash without forking the package.  negotiable marks the type as safe for a peer to
+// switch to mid-message; see ChecksumRegistry.RegisterNegotiable.
+func (ch *TChannel) RegisterChecksumType(typeCode ChecksumType, size int, factory func() Checksum, negotiable bool) {
+	if negotiable {
+		ch.checksumRegistry.RegisterNegotiable(typeCode, size, factory)
+	} else {
+		ch.checksumRegistry.Register(typeCode, size, factory)
+	}
+}
+
+// advertisedChecksumTypes returns the checksum type codes this channel supports, in
+// preference order (strongest first), for advertisement during the init handshake
+func (ch *TChannel) advertisedChecksumTypes() []ChecksumType {
+	return ch.checksumRegistry.PreferenceOrder()
+}
+
+// notifyProtocolHandlers invokes the registered handler for each sub-protocol that conn
+// negotiated with its peer
+func (ch *TChannel) notifyProtocolHandlers(conn *TChannelConnection) {
+	for name, p := range ch.protocols {
+		if conn.SupportsProtocol(name) {
+			p.handler.HandleInit(conn)
+		}
+	}
+}
+
 // BeginCall starts a new call to a remote peer, returning an OutboundCall that can
 // be used to write the arguments of the call
 // TODO(mmihic): Support CallOptions such as format, request specific checksums, retries, etc
 func (ch *TChannel) BeginCall(ctx context.Context, hostPort,
 	serviceName, operationName string) (*OutboundCall, error) {
-	// TODO(mmihic): Keep-alive, manage pools, use existing inbound if possible, all that jazz
-	nconn, err := net.Dial("tcp", hostPort)
-	if err != nil {
-		return nil, err
+	// If ctx carries the span of an inbound call this process is handling (placed there
+	// by dispatchInbound via ContextWithSpan), derive a child span for this outbound hop
+	// so the two calls chain into one trace, and stash it back on ctx. conn.beginCall is
+	// expected to pull it back out via SpanFromContext and set the CallHeaderTrace*
+	// headers on the outbound CallReq, the same way handleCallReq reads them back out on
+	// the inbound side via spanFromHeaders.
+	if span, ok := SpanFromContext(ctx); ok {
+		child := span.NewChildSpan()
+		ctx = ContextWithSpan(ctx, child)
+		if ch.traceReporter != nil {
+			ch.traceReporter.SpanStarted(child, serviceName, operationName)
+		}
 	}
 
-	conn, err := newOutboundConnection(ch, nconn, &ch.connectionOptions)
+	conn, err := ch.pool.GetConnection(ctx, hostPort)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := conn.sendInit(ctx); err != nil {
-		return nil, err
-	}
-
 	call, err := conn.beginCall(ctx, serviceName)
 	if err != nil {
 		return nil, err
@@ -147,32 +289,153 @@ func (ch *TChannel) BeginCall(ctx context.Context, hostPort,
 	return call, nil
 }
 
+// dialConnection dials a new outbound TCP connection to hostPort and wraps it in a
+// TChannelConnection, without performing the init handshake
+func dialConnection(ch *TChannel, hostPort string) (*TChannelConnection, error) {
+	nconn, err := net.Dial("tcp", hostPort)
+	if err != nil {
+		return nil, err
+	}
+
+	return newOutboundConnection(ch, nconn, &ch.connectionOptions)
+}
+
 // RoundTrip calls a peer and waits for the response
 func (ch *TChannel) RoundTrip(ctx context.Context, hostPort, serviceName, operationName string,
 	reqArg2, reqArg3 Output, resArg2, resArg3 Input) (bool, error) {
+	applicationError, _, err := ch.RoundTripWithOptions(ctx, hostPort, serviceName, operationName,
+		reqArg2, reqArg3, resArg2, resArg3, nil)
+	return applicationError, err
+}
+
+// RoundTripWithOptions calls a peer and waits for the response, like RoundTrip, but also
+// accepts CallOptions controlling retry behavior.  It returns the number of attempts made
+// (1 if the call succeeded or failed on the first try) alongside the usual result, so
+// callers can observe retries for metrics purposes.
+func (ch *TChannel) RoundTripWithOptions(ctx context.Context, hostPort, serviceName, operationName string,
+	reqArg2, reqArg3 Output, resArg2, resArg3 Input, opts *CallOptions) (bool, int, error) {
+
+	if opts == nil {
+		opts = &CallOptions{}
+	}
+
+	retry := DefaultRetryPolicy
+	if opts.Retry != nil {
+		retry = opts.Retry.withDefaults()
+	}
+	maxAttempts := 1
+	if opts.Idempotent {
+		maxAttempts = retry.MaxAttempts
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(retry.delayFor(attempt - 1)):
+			case <-ctx.Done():
+				return false, attempt - 1, ctx.Err()
+			}
+		}
+
+		applicationError, retriable, err := ch.roundTripOnce(ctx, hostPort, serviceName,
+			operationName, reqArg2, reqArg3, resArg2, resArg3)
+		if err == nil {
+			return applicationError, attempt, nil
+		}
+
+		lastErr = err
+		if !retriable || !isRetriableError(err) {
+			return false, attempt, err
+		}
+	}
+
+	return false, maxAttempts, lastErr
+}
+
+// roundTripOnce performs a single attempt of a round trip call.  The second return value
+// is false once arg2/arg3 bytes from the response have started arriving on the wire, since
+// at that point a retry could duplicate a side effect the peer has already begun acting on.
+func (ch *TChannel) roundTripOnce(ctx context.Context, hostPort, serviceName, operationName string,
+	reqArg2, reqArg3 Output, resArg2, resArg3 Input) (bool, bool, error) {
 
 	call, err := ch.BeginCall(ctx, hostPort, serviceName, operationName)
 	if err != nil {
-		return false, err
+		return false, true, err
 	}
 
 	if err := call.WriteArg2(reqArg2); err != nil {
-		return false, err
+		return false, true, err
 	}
 
 	if err := call.WriteArg3(reqArg3); err != nil {
-		return false, err
+		return false, true, err
 	}
 
 	if err := call.Response().ReadArg2(resArg2); err != nil {
-		return false, err
+		return false, false, err
 	}
 
 	if err := call.Response().ReadArg3(resArg3); err != nil {
-		return false, err
+		return false, false, err
+	}
+
+	return call.Response().ApplicationError(), false, nil
+}
+
+// Close begins a graceful shutdown of the channel: the listener is closed so no further
+// connections are accepted, every tracked inbound connection is given a chance to finish
+// (or time out) its in-flight calls, and then the underlying sockets are closed.  Close
+// blocks until every connection has finished shutting down or ctx is done, whichever
+// comes first.
+func (ch *TChannel) Close(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&ch.closing, 0, 1) {
+		return nil
+	}
+
+	if ch.l != nil {
+		ch.l.Close()
+	}
+
+	if ch.releaseNAT != nil {
+		ch.releaseNAT()
+	}
+
+	// Drain every connection this channel knows about - both those accepted by
+	// ListenAndHandle (tracked in inboundConns) and those BeginCall/RoundTrip opened via
+	// ch.pool - so Close doesn't leave any readFrames/writeFrames goroutine running.
+	// addInbound also registers accepted connections with ch.pool, so dedupe by identity
+	// before starting a drain goroutine for each one.
+	seen := make(map[*TChannelConnection]struct{})
+	ch.inboundConns.Range(func(key, _ interface{}) bool {
+		seen[key.(*TChannelConnection)] = struct{}{}
+		return true
+	})
+	for _, conn := range ch.pool.all() {
+		seen[conn] = struct{}{}
 	}
 
-	return call.Response().ApplicationError(), nil
+	var wg sync.WaitGroup
+	for conn := range seen {
+		wg.Add(1)
+		go func(conn *TChannelConnection) {
+			defer wg.Done()
+			conn.startClose(ctx)
+		}(conn)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // ListenAndHandle runs a listener to accept and manage new incoming connections.
@@ -181,6 +444,10 @@ func (ch *TChannel) ListenAndHandle() error {
 	acceptBackoff := 0 * time.Millisecond
 
 	for {
+		if atomic.LoadInt32(&ch.closing) != 0 {
+			return nil
+		}
+
 		netConn, err := ch.l.Accept()
 		if err != nil {
 			// Backoff from new accepts if this is a temporary error
@@ -204,7 +471,11 @@ func (ch *TChannel) ListenAndHandle() error {
 
 		acceptBackoff = 0
 
-		_, err = newInboundConnection(ch, netConn, &ch.connectionOptions)
+		// The connection registers itself with ch.pool once the init handshake completes
+		// and we know the remote peer's advertised HostPort, so a server-initiated call
+		// back to this peer can reuse the socket it just accepted rather than dialing a
+		// brand new outbound connection to it.
+		conn, err := newInboundConnection(ch, netConn, &ch.connectionOptions)
 		if err != nil {
 			// Server is getting overloaded - begin rejecting new connections
 			ch.log.Errorf("could not create new TChannelConnection for incoming conn: %v", err)
@@ -212,6 +483,8 @@ func (ch *TChannel) ListenAndHandle() error {
 			continue
 		}
 
-		// TODO(mmihic): Register connection so we can close them when the channel is closed
+		// Track the connection so Close can wait for its in-flight inbound calls to
+		// finish (or time out) before shutting down the channel
+		ch.inboundConns.Store(conn, struct{}{})
 	}
 }
@@ -0,0 +1,112 @@
+package tchannel
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// A NAT maps a local listener port to an externally reachable address/port, so that a
+// TChannel behind a NAT gateway can advertise a HostPort that peers outside the NAT can
+// actually dial.  Implementations only carry out single requests; NATTraversal refreshes
+// the lease before it expires and releases the mapping on Close.
+//
+// UPnP IGD and NAT-PMP implementations of this interface belong in their own files
+// (natupnp.go, natpmp.go) following the same split devp2p uses; this change lands the
+// pluggable interface and the TChannel-side wiring, so tests can supply a fake NAT ahead
+// of those concrete drivers landing.
+type NAT interface {
+	// ExternalAddr returns the externally visible IP address of the gateway
+	ExternalAddr() (net.IP, error)
+
+	// AddMapping requests that external traffic on extPort be forwarded to intPort on this
+	// host, returning the lease duration granted by the gateway
+	AddMapping(protocol string, extPort, intPort int, description string, lifetime time.Duration) (time.Duration, error)
+
+	// DeleteMapping releases a previously requested mapping
+	DeleteMapping(protocol string, extPort, intPort int) error
+}
+
+// NATTraversal drives a NAT implementation on behalf of a TChannel: it discovers the
+// external address, requests a port mapping for the channel's listener, and periodically
+// refreshes the lease until Close is called.
+type NATTraversal struct {
+	// Impl is the underlying NAT gateway protocol to use (UPnP IGD, NAT-PMP, or a test fake)
+	Impl NAT
+
+	// MappingLifetime is how long to request each port mapping be held for.  Defaults to 20 minutes.
+	MappingLifetime time.Duration
+
+	stopCh chan struct{}
+	proto  string
+	port   int
+}
+
+const defaultMappingLifetime = 20 * time.Minute
+
+// applyTo discovers the external gateway, requests a port mapping for localPort, and
+// rewrites hostPort to the externally reachable IP:port.  It spawns a background
+// goroutine that refreshes the lease until release is called.
+func (n *NATTraversal) applyTo(localPort int) (externalHostPort string, release func(), err error) {
+	if n.Impl == nil {
+		return "", func() {}, fmt.Errorf("no NAT implementation configured")
+	}
+
+	lifetime := n.MappingLifetime
+	if lifetime <= 0 {
+		lifetime = defaultMappingLifetime
+	}
+
+	extAddr, err := n.Impl.ExternalAddr()
+	if err != nil {
+		return "", nil, err
+	}
+
+	granted, err := n.Impl.AddMapping("tcp", localPort, localPort, "tchannel", lifetime)
+	if err != nil {
+		return "", nil, err
+	}
+
+	n.proto = "tcp"
+	n.port = localPort
+	n.stopCh = make(chan struct{})
+
+	refresh := granted
+	if refresh <= 0 {
+		refresh = lifetime
+	}
+
+	go n.refreshLoop(refresh, lifetime)
+
+	hostPort := net.JoinHostPort(extAddr.String(), fmt.Sprintf("%d", localPort))
+	return hostPort, n.release, nil
+}
+
+// refreshLoop re-requests the port mapping shortly before each lease expires
+func (n *NATTraversal) refreshLoop(refreshEvery, lifetime time.Duration) {
+	// Refresh a bit ahead of expiry so we never let the mapping lapse
+	interval := refreshEvery - refreshEvery/10
+	if interval <= 0 {
+		interval = refreshEvery
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.Impl.AddMapping(n.proto, n.port, n.port, "tchannel", lifetime)
+		case <-n.stopCh:
+			return
+		}
+	}
+}
+
+// release stops refreshing the lease and deletes the port mapping from the gateway
+func (n *NATTraversal) release() {
+	if n.stopCh != nil {
+		close(n.stopCh)
+	}
+	n.Impl.DeleteMapping(n.proto, n.port, n.port)
+}
@@ -0,0 +1,91 @@
+package tchannel
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeNAT is the kind of test fake NAT's doc comment says the interface exists for: an
+// in-memory stand-in for a UPnP IGD/NAT-PMP gateway that records every call it receives.
+type fakeNAT struct {
+	externalIP net.IP
+
+	addMappingCalls    int
+	deleteMappingCalls int
+	grantedLifetime    time.Duration
+	externalAddrErr    error
+}
+
+func (n *fakeNAT) ExternalAddr() (net.IP, error) {
+	if n.externalAddrErr != nil {
+		return nil, n.externalAddrErr
+	}
+	return n.externalIP, nil
+}
+
+func (n *fakeNAT) AddMapping(protocol string, extPort, intPort int, description string, lifetime time.Duration) (time.Duration, error) {
+	n.addMappingCalls++
+	if n.grantedLifetime > 0 {
+		return n.grantedLifetime, nil
+	}
+	return lifetime, nil
+}
+
+func (n *fakeNAT) DeleteMapping(protocol string, extPort, intPort int) error {
+	n.deleteMappingCalls++
+	return nil
+}
+
+func TestNATTraversalApplyTo(t *testing.T) {
+	nat := &fakeNAT{externalIP: net.ParseIP("203.0.113.5")}
+	nt := &NATTraversal{Impl: nat, MappingLifetime: time.Hour}
+
+	hostPort, release, err := nt.applyTo(4040)
+	if err != nil {
+		t.Fatalf("applyTo: %v", err)
+	}
+	defer release()
+
+	if want := "203.0.113.5:4040"; hostPort != want {
+		t.Fatalf("hostPort = %q; want %q", hostPort, want)
+	}
+	if nat.addMappingCalls != 1 {
+		t.Fatalf("addMappingCalls = %d; want 1", nat.addMappingCalls)
+	}
+}
+
+func TestNATTraversalApplyToNoImpl(t *testing.T) {
+	nt := &NATTraversal{}
+	if _, _, err := nt.applyTo(4040); err == nil {
+		t.Fatalf("applyTo with no Impl should fail")
+	}
+}
+
+func TestNATTraversalApplyToExternalAddrError(t *testing.T) {
+	nat := &fakeNAT{externalAddrErr: fmt.Errorf("gateway unreachable")}
+	nt := &NATTraversal{Impl: nat}
+
+	if _, _, err := nt.applyTo(4040); err == nil {
+		t.Fatalf("applyTo should surface ExternalAddr's error")
+	}
+	if nat.addMappingCalls != 0 {
+		t.Fatalf("addMappingCalls = %d; want 0 - AddMapping should not run after ExternalAddr fails", nat.addMappingCalls)
+	}
+}
+
+func TestNATTraversalRelease(t *testing.T) {
+	nat := &fakeNAT{externalIP: net.ParseIP("203.0.113.5")}
+	nt := &NATTraversal{Impl: nat, MappingLifetime: time.Hour}
+
+	_, release, err := nt.applyTo(4040)
+	if err != nil {
+		t.Fatalf("applyTo: %v", err)
+	}
+
+	release()
+	if nat.deleteMappingCalls != 1 {
+		t.Fatalf("deleteMappingCalls = %d; want 1", nat.deleteMappingCalls)
+	}
+}
@@ -0,0 +1,158 @@
+package tchannel
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// ConnectionPool keeps track of live connections to remote peers, keyed by hostPort,
+// so that BeginCall and ListenAndHandle can multiplex many outbound calls over a single
+// socket instead of dialing a fresh connection for every call.
+type ConnectionPool struct {
+	ch             *TChannel
+	mut            sync.Mutex
+	conns          map[string][]*TChannelConnection
+	maxConnsPerDst int
+
+	// dialMuts serializes dialing a fresh connection to a given hostPort, so a burst of
+	// concurrent GetConnection calls for a peer with no live connection yet shares the
+	// one connection that gets dialed instead of each caller independently dialing and
+	// registering its own
+	dialMuts map[string]*sync.Mutex
+}
+
+// The default number of simultaneous connections the pool will keep open to a single peer
+// before it starts handing out already-open connections instead of dialing new ones
+const defaultMaxConnsPerDst = 1
+
+// NewConnectionPool creates a new, empty ConnectionPool for the given channel
+func NewConnectionPool(ch *TChannel) *ConnectionPool {
+	return &ConnectionPool{
+		ch:             ch,
+		conns:          make(map[string][]*TChannelConnection),
+		maxConnsPerDst: defaultMaxConnsPerDst,
+		dialMuts:       make(map[string]*sync.Mutex),
+	}
+}
+
+// GetConnection returns a connection to hostPort, reusing an existing outbound or inbound
+// connection to that peer if one is available and the pool is already at maxConnsPerDst
+// for it, or dialing and performing the init handshake against a new one otherwise.
+func (p *ConnectionPool) GetConnection(ctx context.Context, hostPort string) (*TChannelConnection, error) {
+	if conn := p.pick(hostPort); conn != nil {
+		return conn, nil
+	}
+
+	// Serialize dialing a fresh connection to hostPort: without this, a burst of
+	// concurrent callers that each find no live (or under-cap) connection to reuse would
+	// each independently dial, init, and register their own, blowing straight past
+	// maxConnsPerDst instead of sharing the one connection that needed to be dialed.
+	dialMut := p.dialLockFor(hostPort)
+	dialMut.Lock()
+	defer dialMut.Unlock()
+
+	// Another caller may have dialed (or filled the cap) while we were waiting for dialMut
+	if conn := p.pick(hostPort); conn != nil {
+		return conn, nil
+	}
+
+	conn, err := dialConnection(p.ch, hostPort)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.sendInit(ctx); err != nil {
+		return nil, err
+	}
+
+	p.add(hostPort, conn)
+	return conn, nil
+}
+
+// pick returns a connection to reuse for hostPort - nil if a new one should be dialed
+// instead. Dead connections are evicted from the tracked list as they're found. While the
+// live count is under maxConnsPerDst, pick returns nil so the caller dials another
+// connection and spreads load across up to maxConnsPerDst sockets; once the cap is
+// reached, the overflow policy is to reuse the most recently added live connection rather
+// than reject or keep growing.
+func (p *ConnectionPool) pick(hostPort string) *TChannelConnection {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	conns := p.conns[hostPort]
+	if len(conns) == 0 {
+		return nil
+	}
+
+	live := conns[:0]
+	for _, c := range conns {
+		if c.IsActive() {
+			live = append(live, c)
+		}
+	}
+	p.conns[hostPort] = live
+
+	if len(live) == 0 || len(live) < p.maxConnsPerDst {
+		return nil
+	}
+
+	return live[len(live)-1]
+}
+
+// dialLockFor returns the mutex that serializes dialing a connection to hostPort,
+// creating one on first use
+func (p *ConnectionPool) dialLockFor(hostPort string) *sync.Mutex {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	m, ok := p.dialMuts[hostPort]
+	if !ok {
+		m = &sync.Mutex{}
+		p.dialMuts[hostPort] = m
+	}
+	return m
+}
+
+// add registers a connection to hostPort with the pool
+func (p *ConnectionPool) add(hostPort string, conn *TChannelConnection) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	p.conns[hostPort] = append(p.conns[hostPort], conn)
+}
+
+// addInbound registers a connection accepted by ListenAndHandle with the pool, keyed by the
+// remote peer's advertised HostPort, so a server-initiated call back to that peer can reuse
+// the already-open socket instead of dialing a new one
+func (p *ConnectionPool) addInbound(conn *TChannelConnection) {
+	p.add(conn.RemotePeerInfo().HostPort, conn)
+}
+
+// all returns a snapshot of every connection currently tracked by the pool, outbound and
+// inbound alike, for Close to drain on shutdown
+func (p *ConnectionPool) all() []*TChannelConnection {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	var conns []*TChannelConnection
+	for _, perHost := range p.conns {
+		conns = append(conns, perHost...)
+	}
+	return conns
+}
+
+// remove evicts a connection from the pool, typically called once the connection has
+// encountered a connectionError and is no longer usable
+func (p *ConnectionPool) remove(hostPort string, conn *TChannelConnection) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	conns := p.conns[hostPort]
+	for i, c := range conns {
+		if c == conn {
+			p.conns[hostPort] = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package tchannel
+
+import (
+	"math/rand"
+	"net"
+	"time"
+)
+
+// A RetryPolicy controls whether and how RoundTripWithOptions retries a failed call,
+// using the exponential-backoff-with-jitter algorithm: the delay before the nth retry is
+// min(BaseDelay * Factor^(n-1), MaxDelay), scaled by a random factor in [1-Jitter,
+// 1+Jitter] and capped at MaxDelay again.
+type RetryPolicy struct {
+	// BaseDelay is the delay before the first retry.  Defaults to 1s.
+	BaseDelay time.Duration
+
+	// Factor is the multiplier applied to the delay on each subsequent attempt.  Defaults to 1.6.
+	Factor float64
+
+	// Jitter is the fraction by which the computed delay is randomly varied, to avoid
+	// a thundering herd of retries all landing at once.  Defaults to 0.2.
+	Jitter float64
+
+	// MaxDelay caps the computed delay, no matter how many attempts have been made.  Defaults to 120s.
+	MaxDelay time.Duration
+
+	// MaxAttempts is the maximum number of times the call will be attempted, including
+	// the first.  Defaults to 4.  Only relevant for idempotent calls - RoundTripWithOptions
+	// never retries a call that doesn't set CallOptions.Idempotent, regardless of this value.
+	MaxAttempts int
+}
+
+// DefaultRetryPolicy is used when a CallOptions does not specify one, and also supplies the
+// fallback values withDefaults uses for any zero-valued field of an explicit RetryPolicy -
+// including MaxAttempts: 4, so "&RetryPolicy{}" with CallOptions.Idempotent gets sensible
+// backoff and more than one attempt without the caller having to guess a count.
+var DefaultRetryPolicy = RetryPolicy{
+	BaseDelay:   time.Second,
+	Factor:      1.6,
+	Jitter:      0.2,
+	MaxDelay:    120 * time.Second,
+	MaxAttempts: 4,
+}
+
+// withDefaults returns a copy of p with zero-valued fields filled in from DefaultRetryPolicy
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.Factor <= 0 {
+		p.Factor = DefaultRetryPolicy.Factor
+	}
+	if p.Jitter <= 0 {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	return p
+}
+
+// delayFor returns the backoff delay to wait before retry attempt n (1-based: the delay
+// before the 2nd overall attempt is delayFor(1))
+func (p RetryPolicy) delayFor(n int) time.Duration {
+	delay := float64(p.BaseDelay)
+	for i := 0; i < n-1; i++ {
+		delay *= p.Factor
+		if delay > float64(p.MaxDelay) {
+			delay = float64(p.MaxDelay)
+			break
+		}
+	}
+
+	jitter := 1 + p.Jitter*(2*rand.Float64()-1)
+	delay *= jitter
+
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	return time.Duration(delay)
+}
+
+// CallOptions controls per-call behavior for BeginCall/RoundTrip
+type CallOptions struct {
+	// Idempotent marks the call as safe to retry; non-idempotent calls are never retried
+	// no matter what RetryPolicy is configured, since the server may have already
+	// performed non-repeatable work as a side effect of the first attempt. Idempotent
+	// alone is enough to get retried with backoff: it's retried up to DefaultRetryPolicy's
+	// MaxAttempts (4) unless Retry says otherwise, not a single attempt.
+	Idempotent bool
+
+	// Retry controls the retry behavior for idempotent calls.  Defaults to DefaultRetryPolicy
+	// if nil; a non-nil Retry still has any zero-valued field - including MaxAttempts -
+	// filled in from DefaultRetryPolicy.
+	Retry *RetryPolicy
+}
+
+// isRetriableError returns true for connection-level failures that are safe to retry
+// against a fresh connection - as opposed to errors that indicate the peer actively
+// rejected the call, which a retry against the same or a different peer won't fix.
+func isRetriableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch err {
+	case ErrConnectionClosed, ErrConnectionNotReady, ErrSendBufferFull:
+		return true
+	}
+
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	return false
+}
@@ -2,9 +2,13 @@ package tchannel
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"code.google.com/p/go.net/context"
 	"github.com/op/go-logging"
@@ -18,39 +22,269 @@ var (
 	ErrInboundRequestAlreadyActive      = errors.New("inbound request is already active; possible duplicate client id")
 )
 
+// CallHeaderProtocol is the CallReq header key a caller uses to indicate that the call
+// requires support for a particular negotiated sub-protocol
+const CallHeaderProtocol = "sp"
+
+// ErrorCodeBusy indicates the peer is temporarily overloaded and the caller should retry
+// against another peer; sent when a pipeline's in-flight byte budget is exhausted
+const ErrorCodeBusy ErrorCode = 0x03
+
+// defaultMaxInFlightBytes bounds, by default, how many continuation-frame bytes a pipeline
+// will buffer ahead of the handlers that will eventually read them
+const defaultMaxInFlightBytes = 4 << 20 // 4 MiB
+
+// inFlightBytesPollInterval bounds how long reserveInFlightBytes waits between checks of
+// the budget while it is exhausted
+const inFlightBytesPollInterval = 10 * time.Millisecond
+
+// inboundReq tracks the channel used to deliver CallReqContinue frames to a dispatched
+// call, along with the call's context so handleCallReqContinue can apply backpressure up
+// to the call's deadline rather than dropping frames outright
+type inboundReq struct {
+	ch  chan *Frame
+	ctx context.Context
+}
+
 // Pipeline for handling incoming requests for service
 type inboundCallPipeline struct {
-	handlers       *handlerMap
-	remotePeerInfo PeerInfo
-	activeReqChs   map[uint32]chan *Frame
-	sendCh         chan<- *Frame
-	reqLock        sync.Mutex
-	framePool      FramePool
-	log            *logging.Logger
+	handlers            *handlerMap
+	remotePeerInfo      PeerInfo
+	activeReqChs        map[uint32]*inboundReq
+	sendCh              chan<- *Frame
+	reqLock             sync.Mutex
+	framePool           FramePool
+	log                 *logging.Logger
+	protoLock           sync.RWMutex
+	negotiatedProtocols map[string][]uint16
+	inFlight            sync.WaitGroup
+	closing             int32
+	compressors         *compressorRegistry
+	traceReporter       TraceReporter
+	maxInFlightBytes    int64
+	inFlightBytes       int64
+	interceptors        []Interceptor
+	bufferPool          FragmentBufferPool
+	checksumRegistry    *ChecksumRegistry
+	controlHandler      ControlHandler
+
+	checksumLock              sync.RWMutex
+	negotiatedChecksumType    ChecksumType
+	hasNegotiatedChecksumType bool
+}
+
+// setInterceptors records the middleware chain that dispatchInbound wraps around every
+// call's handler
+func (p *inboundCallPipeline) setInterceptors(interceptors []Interceptor) {
+	p.interceptors = interceptors
+}
+
+// setBufferPool records the pool used to obtain scratch buffers for assembling vectored
+// arg3 writes on responses dispatched through this pipeline
+func (p *inboundCallPipeline) setBufferPool(pool FragmentBufferPool) {
+	p.bufferPool = pool
+}
+
+// setCompressors records the codecs available for negotiating arg2/arg3 compression on
+// calls dispatched through this pipeline
+func (p *inboundCallPipeline) setCompressors(compressors *compressorRegistry) {
+	p.compressors = compressors
+}
+
+// setChecksumRegistry records the ChecksumRegistry used to construct and size checksums
+// for calls dispatched through this pipeline
+func (p *inboundCallPipeline) setChecksumRegistry(registry *ChecksumRegistry) {
+	p.checksumRegistry = registry
+}
+
+// setControlHandler records the handler notified of inbound ControlFragments - e.g.
+// keepalive pings, soft-cancels, or flow-update credit grants - received on calls
+// dispatched through this pipeline
+func (p *inboundCallPipeline) setControlHandler(handler ControlHandler) {
+	p.controlHandler = handler
+}
+
+// dispatchControl notifies the configured ControlHandler, if any, of an inbound
+// ControlFragment
+func (p *inboundCallPipeline) dispatchControl(ctrl ControlFragment) {
+	if p.controlHandler != nil {
+		p.controlHandler.HandleControl(ctrl)
+	}
+}
+
+// setNegotiatedChecksumType records the checksum type this pipeline's connection
+// negotiated with its peer during the init handshake, so new outbound responses prefer it
+// over the long-standing CRC32 default
+func (p *inboundCallPipeline) setNegotiatedChecksumType(typeCode ChecksumType) {
+	p.checksumLock.Lock()
+	p.negotiatedChecksumType = typeCode
+	p.hasNegotiatedChecksumType = true
+	p.checksumLock.Unlock()
+}
+
+// newChecksum constructs the checksum used for a response's first fragment: the type
+// negotiated with the peer during the init handshake, if any and still registered,
+// otherwise the original CRC32 default
+func (p *inboundCallPipeline) newChecksum() Checksum {
+	p.checksumLock.RLock()
+	typeCode, hasNegotiated := p.negotiatedChecksumType, p.hasNegotiatedChecksumType
+	p.checksumLock.RUnlock()
+
+	if hasNegotiated {
+		if c, err := p.checksumRegistry.New(typeCode); err == nil {
+			return c
+		}
+	}
+
+	return ChecksumTypeCrc32.New()
+}
+
+// setTraceReporter records the TraceReporter that should be notified of span lifecycle
+// events for calls dispatched through this pipeline
+func (p *inboundCallPipeline) setTraceReporter(reporter TraceReporter) {
+	p.traceReporter = reporter
+}
+
+// reportSpanStarted notifies the configured TraceReporter, if any, that span has begun
+func (p *inboundCallPipeline) reportSpanStarted(span Span, serviceName, operation string) {
+	if p.traceReporter != nil {
+		p.traceReporter.SpanStarted(span, serviceName, operation)
+	}
+}
+
+// reportSpanFinished notifies the configured TraceReporter, if any, that span has completed
+func (p *inboundCallPipeline) reportSpanFinished(span Span, err error) {
+	if p.traceReporter != nil {
+		p.traceReporter.SpanFinished(span, err)
+	}
+}
+
+// beginClosing marks the pipeline as closing; new inbound calls are rejected from this
+// point on, while already-dispatched calls are left alone to finish naturally
+func (p *inboundCallPipeline) beginClosing() {
+	atomic.StoreInt32(&p.closing, 1)
+}
+
+func (p *inboundCallPipeline) isClosing() bool {
+	return atomic.LoadInt32(&p.closing) != 0
+}
+
+// drained returns a channel that is closed once every in-flight inbound call dispatched
+// through this pipeline has completed
+func (p *inboundCallPipeline) drained() <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		p.inFlight.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// setNegotiatedProtocols records the sub-protocols the connection owning this pipeline
+// negotiated with its peer during the init handshake
+func (p *inboundCallPipeline) setNegotiatedProtocols(protocols map[string][]uint16) {
+	p.protoLock.Lock()
+	p.negotiatedProtocols = protocols
+	p.protoLock.Unlock()
+}
+
+// supportsProtocol returns true if the peer advertised support for the named sub-protocol
+func (p *inboundCallPipeline) supportsProtocol(name string) bool {
+	p.protoLock.RLock()
+	defer p.protoLock.RUnlock()
+
+	_, ok := p.negotiatedProtocols[name]
+	return ok
+}
+
+// sendError sends a standalone error frame for a call that was rejected before an
+// InboundCallResponse could be constructed for it
+func (p *inboundCallPipeline) sendError(id uint32, code ErrorCode, msg string) {
+	frame, err := MarshalMessage(&ErrorMessage{
+		id:                id,
+		OriginalMessageId: id,
+		ErrorCode:         code,
+		Message:           msg,
+	}, p.framePool)
+	if err != nil {
+		p.log.Warning("Could not create error frame to %s for %d: %v", p.remotePeerInfo, id, err)
+		return
+	}
+
+	select {
+	case p.sendCh <- frame:
+	default:
+		p.log.Warning("Could not send error frame to %s for %d", p.remotePeerInfo, id)
+	}
+}
+
+// reserveInFlightBytes blocks until n bytes of the pipeline's in-flight budget are free,
+// returning false if ctx completes first
+func (p *inboundCallPipeline) reserveInFlightBytes(ctx context.Context, n int64) bool {
+	for {
+		cur := atomic.LoadInt64(&p.inFlightBytes)
+		if cur+n <= p.maxInFlightBytes {
+			if atomic.CompareAndSwapInt64(&p.inFlightBytes, cur, cur+n) {
+				return true
+			}
+			continue
+		}
+
+		select {
+		case <-time.After(inFlightBytesPollInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// releaseInFlightBytes returns n bytes to the pipeline's in-flight budget once a handler
+// has consumed the corresponding continuation frame
+func (p *inboundCallPipeline) releaseInFlightBytes(n int64) {
+	atomic.AddInt64(&p.inFlightBytes, -n)
+}
+
+// splitAcceptEncoding parses a comma-separated accept-encoding header value into an
+// ordered list of codec names, in the caller's preference order
+func splitAcceptEncoding(header string) []string {
+	if header == "" {
+		return nil
+	}
+	return strings.Split(header, ",")
 }
 
 // Creates a new pipeline for handling inbound calls
 func newInboundCallPipeline(remotePeerInfo PeerInfo, sendCh chan<- *Frame, handlers *handlerMap,
 	framePool FramePool, log *logging.Logger) *inboundCallPipeline {
 	return &inboundCallPipeline{
-		remotePeerInfo: remotePeerInfo,
-		sendCh:         sendCh,
-		framePool:      framePool,
-		handlers:       handlers,
-		activeReqChs:   make(map[uint32]chan *Frame),
-		log:            log,
+		remotePeerInfo:   remotePeerInfo,
+		sendCh:           sendCh,
+		framePool:        framePool,
+		handlers:         handlers,
+		activeReqChs:     make(map[uint32]*inboundReq),
+		log:              log,
+		maxInFlightBytes: defaultMaxInFlightBytes,
+		checksumRegistry: DefaultChecksumRegistry,
 	}
 }
 
 // Handles an incoming call request, dispatching the call to the worker pool
 func (p *inboundCallPipeline) handleCallReq(frame *Frame) {
+	if p.isClosing() {
+		// The connection is draining in-flight calls ahead of a graceful shutdown;
+		// reject new calls so the caller can retry elsewhere
+		p.sendError(frame.Header.Id, ErrorCodeBadRequest, "connection is closing")
+		return
+	}
+
 	reqCh := make(chan *Frame, 512) // TODO(mmihic): Control incoming buffer size
+	req := &inboundReq{ch: reqCh, ctx: context.Background()}
 	err := p.withReqLock(func() error {
 		if p.activeReqChs[frame.Header.Id] != nil {
 			return ErrInboundRequestAlreadyActive
 		}
 
-		p.activeReqChs[frame.Header.Id] = reqCh
+		p.activeReqChs[frame.Header.Id] = req
 		return nil
 	})
 
@@ -59,8 +293,10 @@ func (p *inboundCallPipeline) handleCallReq(frame *Frame) {
 		return
 	}
 
+	p.inFlight.Add(1)
+
 	var callReq CallReq
-	firstFragment, err := newInboundFragment(frame, &callReq, nil)
+	firstFragment, err := newInboundFragment(frame, &callReq, nil, p.checksumRegistry)
 	if err != nil {
 		// TODO(mmihic): Probably protocol error
 		p.log.Error("Could not decode call req %d from %s: %v",
@@ -68,16 +304,43 @@ func (p *inboundCallPipeline) handleCallReq(frame *Frame) {
 		return
 	}
 
+	if requiredProtocol := callReq.Headers[CallHeaderProtocol]; requiredProtocol != "" &&
+		!p.supportsProtocol(requiredProtocol) {
+		p.log.Error("Rejecting call req %d from %s: peer did not advertise protocol %q",
+			frame.Header.Id, p.remotePeerInfo, requiredProtocol)
+		p.inboundCallComplete(frame.Header.Id)
+		p.sendError(frame.Header.Id, ErrorCodeBadRequest,
+			fmt.Sprintf("peer did not advertise required protocol %q", requiredProtocol))
+		return
+	}
+
+	span := spanFromHeaders(callReq.Headers)
+	streaming := callReq.Headers[CallHeaderStreaming] != ""
+
 	ctx, cancel := context.WithTimeout(context.Background(), callReq.TimeToLive)
+	ctx = ContextWithSpan(ctx, span)
+	p.withReqLock(func() error {
+		req.ctx = ctx
+		return nil
+	})
+
 	res := &InboundCallResponse{
-		id:       frame.Header.Id,
-		pipeline: p,
-		state:    inboundCallResponseReadyToWriteArg2,
-		ctx:      ctx,
-		cancel:   cancel,
-		checksum: ChecksumTypeCrc32.New(), // TODO(mmihic): Make configurable or mirror req?
+		id:        frame.Header.Id,
+		pipeline:  p,
+		state:     inboundCallResponseReadyToWriteArg2,
+		ctx:       ctx,
+		cancel:    cancel,
+		checksum:  p.newChecksum(),
+		span:      span,
+		streaming: streaming,
 	}
 	res.partWriter = newMultiPartWriter(res)
+	res.partWriter.setBufferPool(p.bufferPool)
+	if p.compressors != nil {
+		if encoder := p.compressors.pick(splitAcceptEncoding(callReq.Headers[CallHeaderAcceptEncoding])); encoder != nil {
+			res.encoder = encoder
+		}
+	}
 
 	call := &InboundCall{
 		id:               frame.Header.Id,
@@ -90,6 +353,15 @@ func (p *inboundCallPipeline) handleCallReq(frame *Frame) {
 		recvLastFragment: firstFragment.last,
 		serviceName:      string(callReq.Service),
 		state:            inboundCallPreRead,
+		span:             span,
+		headers:          callReq.Headers,
+		streaming:        streaming,
+		checksum:         firstFragment.checksum,
+	}
+	if p.compressors != nil {
+		if contentEncoding := callReq.Headers[CallHeaderContentEncoding]; contentEncoding != "" {
+			call.decoder = p.compressors.byName(contentEncoding)
+		}
 	}
 
 	go p.dispatchInbound(call)
@@ -97,34 +369,54 @@ func (p *inboundCallPipeline) handleCallReq(frame *Frame) {
 
 // Handles the continuation of a call request.  Adds the frame to the channel for that call.
 func (p *inboundCallPipeline) handleCallReqContinue(frame *Frame) {
-	var reqCh chan<- *Frame
+	var req *inboundReq
 	p.withReqLock(func() error {
-		reqCh = p.activeReqChs[frame.Header.Id]
+		req = p.activeReqChs[frame.Header.Id]
 		return nil
 	})
 
-	if reqCh == nil {
+	if req == nil {
 		// This is ok, just means the request timed out or was cancelled etc
 		return
 	}
 
+	n := int64(len(frame.Payload))
+	if !p.reserveInFlightBytes(req.ctx, n) {
+		// The handler isn't draining fragments fast enough to stay under the
+		// pipeline's in-flight byte budget, and the call's deadline passed while we
+		// waited; tell the peer to back off and retry elsewhere instead of silently
+		// corrupting the call by dropping a fragment mid-stream
+		p.inboundCallComplete(frame.Header.Id)
+		p.sendError(frame.Header.Id, ErrorCodeBusy, "too many in-flight bytes for this call")
+		close(req.ch)
+		return
+	}
+
 	select {
-	case reqCh <- frame:
+	case req.ch <- frame:
 		// Ok
-	default:
-		// Application not reading fragments quickly enough; kill off the request
-		// TODO(mmihic): Send down a server busy error frame
+	case <-req.ctx.Done():
+		p.releaseInFlightBytes(n)
 		p.inboundCallComplete(frame.Header.Id)
-		close(reqCh)
+		p.sendError(frame.Header.Id, ErrorCodeBusy, "call deadline exceeded waiting for handler")
+		close(req.ch)
 	}
 }
 
 // Called when an inbound request has completed (either successfully or due to timeout or error)
 func (p *inboundCallPipeline) inboundCallComplete(messageId uint32) {
+	existed := false
 	p.withReqLock(func() error {
-		delete(p.activeReqChs, messageId)
+		if _, ok := p.activeReqChs[messageId]; ok {
+			existed = true
+			delete(p.activeReqChs, messageId)
+		}
 		return nil
 	})
+
+	if existed {
+		p.inFlight.Done()
+	}
 }
 
 // Performs some action with the inbound request lock held.  Typically involves
@@ -143,9 +435,12 @@ func (p *inboundCallPipeline) dispatchInbound(call *InboundCall) {
 	if err := call.readOperation(); err != nil {
 		p.log.Error("Could not read operation from %s: %v", p.remotePeerInfo, err)
 		p.inboundCallComplete(call.id)
+		p.reportSpanFinished(call.span, err)
 		return
 	}
 
+	p.reportSpanStarted(call.span, call.ServiceName(), string(call.Operation()))
+
 	// NB(mmihic): Don't cast operation name to string here - this will create a copy
 	// of the byte array, where as aliasing to string in the map look up can be optimized
 	// by the compiler to avoid the copy.  See https://github.com/golang/go/issues/3512
@@ -157,7 +452,9 @@ func (p *inboundCallPipeline) dispatchInbound(call *InboundCall) {
 	}
 
 	p.log.Debug("Dispatching %s:%s from %s", call.ServiceName(), call.Operation(), p.remotePeerInfo)
-	h.Handle(call.ctx, call)
+	if err := chainInterceptors(p.interceptors, h)(call.ctx, call); err != nil {
+		call.Response().SendSystemError(err)
+	}
 }
 
 // An InboundCall is an incoming call from a peer
@@ -174,6 +471,46 @@ type InboundCall struct {
 	recvCh           <-chan *Frame
 	curFragment      *inFragment
 	checksum         Checksum
+
+	// decoder, if set, decompresses arg2/arg3 bytes as they are read; chosen from the
+	// CallHeaderContentEncoding header the caller sent on the CallReq
+	decoder Compressor
+
+	// span is the trace span this call belongs to, continued from the caller's
+	// CallHeaderTrace* headers or freshly rooted if the caller did not propagate one
+	span Span
+
+	// headers are the transport headers the caller sent on the CallReq
+	headers CallHeaders
+
+	// streaming is true if the caller negotiated streaming mode via CallHeaderStreaming,
+	// in which case the request body is read via RecvStream rather than ReadArg2/ReadArg3
+	streaming bool
+}
+
+// Headers returns the transport headers the caller sent on the CallReq
+func (call *InboundCall) Headers() CallHeaders {
+	return call.headers
+}
+
+// IsStreaming returns true if this call negotiated streaming mode, in which case the
+// request and response bodies are read and written via RecvStream/SendStream rather than
+// the fixed arg2/arg3 shape
+func (call *InboundCall) IsStreaming() bool {
+	return call.streaming
+}
+
+// Span returns the trace span this call belongs to
+func (call *InboundCall) Span() Span {
+	return call.span
+}
+
+// wrapDecoder wraps r with the call's negotiated decompressor, if any
+func (call *InboundCall) wrapDecoder(r io.Reader) (io.Reader, error) {
+	if call.decoder == nil {
+		return r, nil
+	}
+	return call.decoder.NewReader(r)
 }
 
 type inboundCallState int
@@ -224,7 +561,11 @@ func (call *InboundCall) ReadArg2(arg Input) error {
 	}
 
 	r := newMultiPartReader(call, false)
-	if err := arg.ReadFrom(r); err != nil {
+	decoded, err := call.wrapDecoder(r)
+	if err != nil {
+		return call.failed(err)
+	}
+	if err := arg.ReadFrom(decoded); err != nil {
 		return call.failed(err)
 	}
 
@@ -243,7 +584,11 @@ func (call *InboundCall) ReadArg3(arg Input) error {
 	}
 
 	r := newMultiPartReader(call, true)
-	if err := arg.ReadFrom(r); err != nil {
+	decoded, err := call.wrapDecoder(r)
+	if err != nil {
+		return call.failed(err)
+	}
+	if err := arg.ReadFrom(decoded); err != nil {
 		return call.failed(err)
 	}
 
@@ -259,6 +604,7 @@ func (call *InboundCall) ReadArg3(arg Input) error {
 func (call *InboundCall) failed(err error) error {
 	call.state = inboundCallError
 	call.pipeline.inboundCallComplete(call.id)
+	call.pipeline.reportSpanFinished(call.span, err)
 	return err
 }
 
@@ -273,24 +619,42 @@ func (call *InboundCall) waitForFragment() (*inFragment, error) {
 		return call.curFragment, nil
 	}
 
-	if call.recvLastFragment {
-		return nil, call.failed(io.EOF)
-	}
-
-	select {
-	case <-call.ctx.Done():
-		return nil, call.failed(call.ctx.Err())
-
-	case frame := <-call.recvCh:
-		reqContinue := CallReqContinue{id: call.res.id}
-		fragment, err := newInboundFragment(frame, &reqContinue, call.checksum)
-		if err != nil {
-			return nil, call.failed(err)
+	for {
+		if call.recvLastFragment {
+			return nil, call.failed(io.EOF)
 		}
 
-		call.curFragment = fragment
-		call.recvLastFragment = fragment.last
-		return fragment, nil
+		select {
+		case <-call.ctx.Done():
+			return nil, call.failed(call.ctx.Err())
+
+		case frame := <-call.recvCh:
+			call.pipeline.releaseInFlightBytes(int64(len(frame.Payload)))
+
+			// Control fragments (pings, soft-cancels, flow updates) are multiplexed onto
+			// this call's continuation frames without participating in the
+			// flagMoreFragments bookkeeping; consume and dispatch them transparently
+			// instead of handing them to the caller as part data
+			if isControlFragment(frame) {
+				ctrl, err := newInboundControlFragment(frame, call.pipeline.checksumRegistry)
+				if err != nil {
+					return nil, call.failed(err)
+				}
+				call.pipeline.dispatchControl(ctrl)
+				continue
+			}
+
+			reqContinue := CallReqContinue{id: call.res.id}
+			fragment, err := newInboundFragment(frame, &reqContinue, call.checksum, call.pipeline.checksumRegistry)
+			if err != nil {
+				return nil, call.failed(err)
+			}
+
+			call.curFragment = fragment
+			call.checksum = fragment.checksum
+			call.recvLastFragment = fragment.last
+			return fragment, nil
+		}
 	}
 }
 
@@ -305,6 +669,18 @@ type InboundCallResponse struct {
 	startedFirstFragment bool
 	partWriter           *multiPartWriter
 	applicationError     bool
+
+	// encoder, if set, compresses arg2/arg3 bytes as they are written; chosen from the
+	// intersection of CallHeaderAcceptEncoding on the CallReq and the codecs registered
+	// on the channel
+	encoder Compressor
+
+	// span is the trace span of the call this is a response to
+	span Span
+
+	// streaming is true if the caller negotiated streaming mode via CallHeaderStreaming,
+	// in which case the response body is written via SendStream rather than WriteArg2/WriteArg3
+	streaming bool
 }
 
 type inboundCallResponseState int
@@ -318,31 +694,38 @@ const (
 
 // Sends a system error response to the peer
 func (call *InboundCallResponse) SendSystemError(err error) error {
-	// Fail all future attempts to read fragments
-	call.cancel()
-	call.state = inboundCallResponseComplete
+	// A handler can complete its response and then panic later in a deferred cleanup;
+	// RecoveryInterceptor converts that panic into an error, and dispatchInbound forwards
+	// it here unconditionally. Without this guard that would send a second error frame for
+	// a call the peer already considers finished - and since message ids are reused once a
+	// call completes, that stray frame could be delivered against an unrelated, newly
+	// started call using the same id.
+	if call.state == inboundCallResponseComplete || call.state == inboundCallResponseError {
+		return nil
+	}
 
-	// Send the error frame
-	frame, err := MarshalMessage(&ErrorMessage{
+	// Send the error frame before tearing anything down, so the send can still block on
+	// call.ctx for backpressure instead of racing the cancel below
+	frame, merr := MarshalMessage(&ErrorMessage{
 		id:                call.id,
 		OriginalMessageId: call.id,
 		ErrorCode:         GetSystemErrorCode(err),
 		Message:           err.Error()}, call.pipeline.framePool)
 
-	if err != nil {
+	if merr != nil {
 		// Nothing we can do here
 		call.pipeline.log.Warning("Could not create outbound frame to %s for %d: %v",
-			call.pipeline.remotePeerInfo, call.id, err)
-		return nil
-	}
-
-	select {
-	case call.pipeline.sendCh <- frame: // Good to go
-	default: // Nothing we can do here anyway
+			call.pipeline.remotePeerInfo, call.id, merr)
+	} else if sendErr := call.sendFrame(frame); sendErr != nil {
 		call.pipeline.log.Warning("Could not send error frame to %s for %d : %v",
-			call.pipeline.remotePeerInfo, call.id, err)
+			call.pipeline.remotePeerInfo, call.id, sendErr)
 	}
 
+	// Fail all future attempts to read fragments
+	call.cancel()
+	call.state = inboundCallResponseComplete
+	call.pipeline.inboundCallComplete(call.id)
+	call.pipeline.reportSpanFinished(call.span, err)
 	return nil
 }
 
@@ -356,13 +739,27 @@ func (call *InboundCallResponse) SetApplicationError() error {
 	return nil
 }
 
+// SetWriterOptions configures how this response's fragments are constructed and
+// delivered to the peer, e.g. to pipeline construction of large streamed arguments
+// across several fragments instead of sending them one at a time.  Must be called
+// before any arguments are begun.
+func (call *InboundCallResponse) SetWriterOptions(opts WriterOptions) error {
+	if call.state != inboundCallResponseReadyToWriteArg2 {
+		return ErrInboundCallResponseStateMismatch
+	}
+
+	call.partWriter = newPipelinedMultiPartWriter(call, opts)
+	call.partWriter.setBufferPool(call.pipeline.bufferPool)
+	return nil
+}
+
 // Writes the second argument in the response
 func (call *InboundCallResponse) WriteArg2(arg Output) error {
 	if call.state != inboundCallResponseReadyToWriteArg2 {
 		return call.failed(ErrInboundCallResponseStateMismatch)
 	}
 
-	if err := arg.WriteTo(call.partWriter); err != nil {
+	if err := call.writeArg(arg); err != nil {
 		return call.failed(err)
 	}
 
@@ -380,7 +777,7 @@ func (call *InboundCallResponse) WriteArg3(arg Output) error {
 		return call.failed(ErrInboundCallResponseStateMismatch)
 	}
 
-	if err := arg.WriteTo(call.partWriter); err != nil {
+	if err := call.writeArg(arg); err != nil {
 		return call.failed(err)
 	}
 
@@ -389,13 +786,30 @@ func (call *InboundCallResponse) WriteArg3(arg Output) error {
 	}
 
 	call.state = inboundCallResponseComplete
+	call.pipeline.inboundCallComplete(call.id)
+	call.pipeline.reportSpanFinished(call.span, nil)
 	return nil
 }
 
+// writeArg writes arg to the response stream, transparently compressing it first if the
+// caller advertised support for a codec this channel also understands
+func (call *InboundCallResponse) writeArg(arg Output) error {
+	if call.encoder == nil {
+		return arg.WriteTo(call.partWriter)
+	}
+
+	w := newCompressWriter(call.encoder, call.partWriter)
+	if err := arg.WriteTo(w); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
 // Marks the call as failed
 func (call *InboundCallResponse) failed(err error) error {
 	call.state = inboundCallResponseError
 	call.pipeline.inboundCallComplete(call.id)
+	call.pipeline.reportSpanFinished(call.span, err)
 	return err
 }
 
@@ -409,27 +823,93 @@ func (call *InboundCallResponse) beginFragment() (*outFragment, error) {
 			responseCode = ResponseApplicationError
 		}
 
+		headers := CallHeaders{}
+		if call.encoder != nil {
+			headers[CallHeaderContentEncoding] = call.encoder.Name()
+		}
+		for k, v := range call.span.headers() {
+			headers[k] = v
+		}
+		if call.streaming {
+			headers[CallHeaderStreaming] = "true"
+		}
+
 		msg = &CallRes{
 			id:           call.id,
 			ResponseCode: responseCode,
-			Headers:      CallHeaders{},
-			// TODO(mmihic): Tracing
+			Headers:      headers,
 		}
 	} else {
 		msg = &CallResContinue{id: call.id}
 	}
 
-	return newOutboundFragment(frame, msg, call.checksum)
+	return newOutboundFragment(frame, msg, call.checksum, call.pipeline.checksumRegistry)
 }
 
 // Sends a response fragment back to the peer
 func (call *InboundCallResponse) flushFragment(f *outFragment, last bool) error {
+	return call.sendFrame(f.finish(last))
+}
+
+// currentChecksum implements checksumSource, letting a multiPartWriter's Checkpoint
+// snapshot the Checksum this response's fragments accumulate into
+func (call *InboundCallResponse) currentChecksum() Checksum {
+	return call.checksum
+}
+
+// sendControl implements outFragmentChannel.sendControl, multiplexing ctrl onto this
+// response's continuation frames without disturbing any fragment partWriter has under
+// construction
+func (call *InboundCallResponse) sendControl(ctrl ControlFragment) error {
+	frame := call.pipeline.framePool.Get()
+	msg := &CallResContinue{id: call.id}
+
+	f, err := newOutboundControlFrame(frame, msg.Id(), msg.Type(), ctrl,
+		call.pipeline.newChecksum(), call.pipeline.checksumRegistry)
+	if err != nil {
+		return err
+	}
+
+	return call.sendFrame(f)
+}
+
+// SendControl sends ctrl to the peer as an in-band control fragment - e.g. a keepalive
+// ping or a flow-update credit grant - multiplexed onto this response's open message
+// without closing the argument stream. Unlike WriteArg2/WriteArg3, it may be called at any
+// point before the response is complete.
+func (call *InboundCallResponse) SendControl(ctrl ControlFragment) error {
+	return call.sendControl(ctrl)
+}
+
+// beginFragmentAsync begins a new response fragment tagged with seq, so a pipelined
+// multiPartWriter can construct several fragments for this response concurrently while
+// still delivering them to the peer in order
+func (call *InboundCallResponse) beginFragmentAsync(seq uint32) (*outFragment, error) {
+	f, err := call.beginFragment()
+	if err != nil {
+		return nil, err
+	}
+
+	f.seq = seq
+	return f, nil
+}
+
+// deliverFrame sends a single already-finished frame to the peer.  Used in place of
+// flushFragment by a pipelined multiPartWriter's reorderBuffer, once the frame's turn to
+// be delivered in wire order has come up.
+func (call *InboundCallResponse) deliverFrame(frame *Frame) error {
+	return call.sendFrame(frame)
+}
+
+// sendFrame delivers frame on the pipeline's send channel, blocking until the socket
+// writer goroutine can accept it or the call's context is done, so a slow consumer
+// blocks the caller instead of a fragment being silently dropped mid-stream
+func (call *InboundCallResponse) sendFrame(frame *Frame) error {
 	select {
-	case call.pipeline.sendCh <- f.finish(last):
+	case call.pipeline.sendCh <- frame:
 		return nil
-	default:
-		// TODO(mmihic): Probably need to abort the whole thing
-		return ErrSendBufferFull
+	case <-call.ctx.Done():
+		return call.ctx.Err()
 	}
 }
 
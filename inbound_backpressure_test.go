@@ -0,0 +1,78 @@
+package tchannel
+
+import (
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+func TestReserveInFlightBytesUnderBudget(t *testing.T) {
+	p := &inboundCallPipeline{maxInFlightBytes: 1024}
+
+	if !p.reserveInFlightBytes(context.Background(), 512) {
+		t.Fatalf("reserveInFlightBytes should succeed under budget")
+	}
+	if p.inFlightBytes != 512 {
+		t.Fatalf("inFlightBytes = %d; want 512", p.inFlightBytes)
+	}
+
+	p.releaseInFlightBytes(512)
+	if p.inFlightBytes != 0 {
+		t.Fatalf("inFlightBytes after release = %d; want 0", p.inFlightBytes)
+	}
+}
+
+func TestReserveInFlightBytesBlocksUntilReleased(t *testing.T) {
+	p := &inboundCallPipeline{maxInFlightBytes: 100}
+
+	if !p.reserveInFlightBytes(context.Background(), 100) {
+		t.Fatalf("initial reserve should succeed, budget is exactly met")
+	}
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- p.reserveInFlightBytes(context.Background(), 1)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("reserveInFlightBytes should block while the budget is exhausted")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	p.releaseInFlightBytes(100)
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatalf("reserveInFlightBytes should succeed once budget is released")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("reserveInFlightBytes did not unblock after release")
+	}
+}
+
+func TestReserveInFlightBytesCtxDone(t *testing.T) {
+	p := &inboundCallPipeline{maxInFlightBytes: 10}
+	if !p.reserveInFlightBytes(context.Background(), 10) {
+		t.Fatalf("initial reserve should succeed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool, 1)
+	go func() {
+		done <- p.reserveInFlightBytes(ctx, 1)
+	}()
+
+	cancel()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatalf("reserveInFlightBytes should report false once ctx is done")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("reserveInFlightBytes did not return after ctx cancellation")
+	}
+}
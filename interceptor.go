@@ -0,0 +1,131 @@
+package tchannel
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// An Interceptor wraps a single call's dispatch to its Handler, forming a middleware chain
+// analogous to gRPC's unary interceptors.  An Interceptor may run logic before and after
+// calling next, and can short-circuit the chain (e.g. reject on failed auth or an exceeded
+// rate limit) by returning a non-nil error without calling next at all.  Because the chain
+// wraps the full call to next.Handle, an Interceptor can also observe the outcome of the
+// response path (WriteArg3, SendSystemError) by inspecting call.Response() once next
+// returns.
+type Interceptor func(ctx context.Context, call *InboundCall, next Handler) error
+
+// chainInterceptors composes interceptors (applied outermost-first) around final into a
+// single function that dispatchInbound can invoke in place of calling final.Handle directly
+func chainInterceptors(interceptors []Interceptor, final Handler) func(ctx context.Context, call *InboundCall) error {
+	chain := func(ctx context.Context, call *InboundCall) error {
+		final.Handle(ctx, call)
+		return nil
+	}
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor := interceptors[i]
+		next := chain
+		chain = func(ctx context.Context, call *InboundCall) error {
+			var innerErr error
+			nextHandler := HandlerFunc(func(ctx context.Context, call *InboundCall) {
+				innerErr = next(ctx, call)
+			})
+
+			if err := interceptor(ctx, call, nextHandler); err != nil {
+				return err
+			}
+			return innerErr
+		}
+	}
+
+	return chain
+}
+
+// RecoveryInterceptor recovers from a panicking handler and turns it into a system error
+// response instead of killing the connection's dispatch goroutine and leaking the call's
+// entry in activeReqChs
+func RecoveryInterceptor() Interceptor {
+	return func(ctx context.Context, call *InboundCall, next Handler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("handler panic: %v", r)
+			}
+		}()
+
+		next.Handle(ctx, call)
+		return nil
+	}
+}
+
+// LoggingInterceptor logs the start and completion of every dispatched call using the
+// fields already tracked on the pipeline (remote peer, service, operation)
+func LoggingInterceptor() Interceptor {
+	return func(ctx context.Context, call *InboundCall, next Handler) error {
+		start := time.Now()
+		call.pipeline.log.Debug("Handling %s:%s from %s",
+			call.ServiceName(), call.Operation(), call.pipeline.remotePeerInfo)
+
+		next.Handle(ctx, call)
+
+		call.pipeline.log.Debug("Handled %s:%s from %s in %v (response state %v)",
+			call.ServiceName(), call.Operation(), call.pipeline.remotePeerInfo,
+			time.Since(start), call.Response().state)
+		return nil
+	}
+}
+
+// rateWindow tracks the number of calls accepted for a service within the current
+// one-second window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// RateLimitInterceptor rejects calls to a service once more than maxPerSecond have been
+// accepted for that service within the current one-second window
+func RateLimitInterceptor(maxPerSecond int) Interceptor {
+	var mut sync.Mutex
+	windows := make(map[string]*rateWindow)
+
+	return func(ctx context.Context, call *InboundCall, next Handler) error {
+		service := call.ServiceName()
+		now := time.Now()
+
+		mut.Lock()
+		w, ok := windows[service]
+		if !ok || now.Sub(w.start) >= time.Second {
+			w = &rateWindow{start: now}
+			windows[service] = w
+		}
+		w.count++
+		exceeded := w.count > maxPerSecond
+		mut.Unlock()
+
+		if exceeded {
+			return NewSystemError(ErrorCodeBusy,
+				fmt.Sprintf("rate limit exceeded for service %q", service))
+		}
+
+		next.Handle(ctx, call)
+		return nil
+	}
+}
+
+// CallHeaderAuthToken is the CallReq transport header carrying an opaque bearer token that
+// AuthInterceptor validates before a call is allowed to reach its handler
+const CallHeaderAuthToken = "at"
+
+// AuthInterceptor rejects any call whose CallHeaderAuthToken does not satisfy validate
+func AuthInterceptor(validate func(token string) bool) Interceptor {
+	return func(ctx context.Context, call *InboundCall, next Handler) error {
+		if !validate(call.Headers()[CallHeaderAuthToken]) {
+			return NewSystemError(ErrorCodeBadRequest, "invalid or missing auth token")
+		}
+
+		next.Handle(ctx, call)
+		return nil
+	}
+}
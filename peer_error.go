@@ -0,0 +1,71 @@
+package tchannel
+
+import "fmt"
+
+// DiscReason identifies why a connection to a peer was torn down
+type DiscReason int
+
+const (
+	DiscRequested DiscReason = iota
+	DiscProtocolError
+	DiscUnsupportedVersion
+	DiscTimeout
+	DiscTooManyPeers
+	DiscRemoteRequested
+	DiscReadError
+	DiscWriteError
+	DiscInvalidMsg
+)
+
+func (r DiscReason) String() string {
+	switch r {
+	case DiscRequested:
+		return "disconnect requested"
+	case DiscProtocolError:
+		return "protocol error"
+	case DiscUnsupportedVersion:
+		return "unsupported protocol version"
+	case DiscTimeout:
+		return "timeout"
+	case DiscTooManyPeers:
+		return "too many peers"
+	case DiscRemoteRequested:
+		return "remote requested disconnect"
+	case DiscReadError:
+		return "read error"
+	case DiscWriteError:
+		return "write error"
+	case DiscInvalidMsg:
+		return "invalid message"
+	default:
+		return fmt.Sprintf("unknown disconnect reason %d", int(r))
+	}
+}
+
+// A PeerError describes why a connection to a peer was closed, carrying both a coarse
+// machine-readable DiscReason and the underlying error (if any) that triggered it.
+type PeerError struct {
+	Reason DiscReason
+	Err    error
+}
+
+func (e *PeerError) Error() string {
+	if e.Err == nil {
+		return e.Reason.String()
+	}
+	return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+}
+
+// NewPeerError creates a PeerError for the given reason, optionally wrapping an
+// underlying error describing what went wrong
+func NewPeerError(reason DiscReason, err error) *PeerError {
+	return &PeerError{Reason: reason, Err: err}
+}
+
+// PeerErrorHandler is notified whenever a connection to a peer is torn down after an
+// identified failure (read, write, protocol or version errors), along with the identity
+// of that peer and the reason for the disconnect; a connection closed by Close or by any
+// other plain connection error does not notify it.  Registered on
+// ChannelOptions so operators can log or react to disconnects without instrumenting every
+// call site that can fail.
+type PeerErrorHandler func(peer PeerInfo, err *PeerError)
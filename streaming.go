@@ -0,0 +1,99 @@
+package tchannel
+
+import (
+	"bytes"
+	"io"
+)
+
+// CallHeaderStreaming is the CallReq header a caller sets to negotiate streaming mode for
+// a call: instead of the fixed arg1/arg2/arg3 shape, the request and response bodies are
+// each a sequence of independently framed messages read via RecvStream/SendStream
+const CallHeaderStreaming = "sm"
+
+// bytesOutput adapts a raw byte slice to the Output interface, so StreamWriter.Send can
+// reuse the same compression machinery as WriteArg2/WriteArg3
+type bytesOutput []byte
+
+// WriteTo implements Output
+func (b bytesOutput) WriteTo(w io.Writer) error {
+	_, err := w.Write(b)
+	return err
+}
+
+// A StreamReader yields the framed messages a peer sends over a streaming call.  Recv
+// returns io.EOF once the peer has sent its final message.
+type StreamReader struct {
+	call *InboundCall
+}
+
+// RecvStream returns a StreamReader for consuming messages sent by the peer on this call.
+// Valid only for calls where IsStreaming() is true.
+func (call *InboundCall) RecvStream() *StreamReader {
+	return &StreamReader{call: call}
+}
+
+// Recv reads the next framed message from the peer.  It returns io.EOF once the peer has
+// sent its last fragment and there are no messages left to read.
+func (s *StreamReader) Recv() ([]byte, error) {
+	call := s.call
+	if call.recvLastFragment && (call.curFragment == nil || !call.curFragment.hasMoreChunks()) {
+		return nil, io.EOF
+	}
+
+	r := newMultiPartReader(call, false)
+	decoded, err := call.wrapDecoder(r)
+	if err != nil {
+		return nil, call.failed(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, decoded); err != nil {
+		return nil, call.failed(err)
+	}
+
+	// By the time decoded has been fully drained, waitForFragment has pulled in whatever
+	// fragment actually carries the end of this message - including the peer's last wire
+	// fragment, if that's what it turned out to be. Tell endPart, so that when this
+	// message's data happens to end exactly on that last fragment's boundary, it doesn't
+	// wait for a terminator fragment the peer - having already sent its last one - will
+	// never send.
+	r.lastPartInMessage = call.recvLastFragment
+	if err := r.endPart(); err != nil {
+		return nil, call.failed(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// A StreamWriter lets a handler send any number of framed messages on a streaming call's
+// response, terminated by CloseSend, instead of the fixed arg2/arg3 shape.
+type StreamWriter struct {
+	call *InboundCallResponse
+}
+
+// SendStream returns a StreamWriter for sending messages to the caller on this call's
+// response. Valid only for calls where IsStreaming() is true.
+func (call *InboundCallResponse) SendStream() *StreamWriter {
+	return &StreamWriter{call: call}
+}
+
+// Send writes a single framed message to the peer
+func (s *StreamWriter) Send(msg []byte) error {
+	if err := s.call.writeArg(bytesOutput(msg)); err != nil {
+		return s.call.failed(err)
+	}
+	return s.call.partWriter.endPart(false)
+}
+
+// CloseSend finishes the response stream, signalling to the peer that no more messages
+// will follow
+func (s *StreamWriter) CloseSend() error {
+	if err := s.call.partWriter.endPart(true); err != nil {
+		return s.call.failed(err)
+	}
+
+	s.call.state = inboundCallResponseComplete
+	s.call.pipeline.inboundCallComplete(s.call.id)
+	s.call.pipeline.reportSpanFinished(s.call.span, nil)
+	return nil
+}
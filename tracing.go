@@ -0,0 +1,215 @@
+package tchannel
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"code.google.com/p/go.net/context"
+)
+
+// Span identifies a unit of work within a distributed trace.  It is propagated across
+// inbound and outbound calls via the CallHeaderTrace* transport headers, so that handlers
+// making outbound calls on behalf of an inbound one can chain spans together into a single
+// trace without any explicit plumbing.
+type Span struct {
+	TraceID  uint64
+	SpanID   uint64
+	ParentID uint64
+	Flags    byte
+}
+
+// CallHeader keys under which a Span is encoded on the wire, as hex-encoded uint64s
+const (
+	CallHeaderTraceID   = "tid"
+	CallHeaderSpanID    = "sid"
+	CallHeaderParentID  = "pid"
+	CallHeaderTraceFlag = "tf"
+)
+
+// NewRootSpan creates a Span with freshly generated TraceID/SpanID and no parent, for use
+// when a call originates a new trace rather than continuing one from a caller
+func NewRootSpan() Span {
+	return Span{TraceID: randomSpanID(), SpanID: randomSpanID()}
+}
+
+// NewChildSpan returns the Span for a call made on behalf of s, sharing s's TraceID and
+// carrying a freshly generated SpanID, so causality flows end-to-end across hops
+func (s Span) NewChildSpan() Span {
+	return Span{TraceID: s.TraceID, SpanID: randomSpanID(), ParentID: s.SpanID, Flags: s.Flags}
+}
+
+// spanContextKey is the context.Value key under which a Span is stashed by ContextWithSpan
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span, so that a handler several calls deep
+// - in particular one making an outbound call via BeginCall/RoundTrip - can find the span
+// of the inbound call it's handling without threading it through every signature along the
+// way
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the Span previously attached to ctx via ContextWithSpan, and
+// false if ctx doesn't carry one
+func SpanFromContext(ctx context.Context) (Span, bool) {
+	span, ok := ctx.Value(spanContextKey{}).(Span)
+	return span, ok
+}
+
+func randomSpanID() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// headers encodes the span into CallHeaders entries for the wire
+func (s Span) headers() CallHeaders {
+	return CallHeaders{
+		CallHeaderTraceID:   fmt.Sprintf("%x", s.TraceID),
+		CallHeaderSpanID:    fmt.Sprintf("%x", s.SpanID),
+		CallHeaderParentID:  fmt.Sprintf("%x", s.ParentID),
+		CallHeaderTraceFlag: fmt.Sprintf("%x", s.Flags),
+	}
+}
+
+// spanFromHeaders extracts a Span from the CallHeaders on an incoming CallReq, returning a
+// fresh root span if the peer did not propagate one (e.g. an older client)
+func spanFromHeaders(headers CallHeaders) Span {
+	tid, ok := parseHexUint64(headers[CallHeaderTraceID])
+	if !ok {
+		return NewRootSpan()
+	}
+
+	sid, _ := parseHexUint64(headers[CallHeaderSpanID])
+	pid, _ := parseHexUint64(headers[CallHeaderParentID])
+	flags, _ := parseHexUint64(headers[CallHeaderTraceFlag])
+	return Span{TraceID: tid, SpanID: sid, ParentID: pid, Flags: byte(flags)}
+}
+
+func parseHexUint64(s string) (uint64, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	var v uint64
+	if _, err := fmt.Sscanf(s, "%x", &v); err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// A TraceReporter receives span lifecycle events, so operators can wire tchannel's tracing
+// into an existing backend without changing handler code.
+type TraceReporter interface {
+	// SpanStarted is called when dispatchInbound begins handling a call, or BeginCall
+	// injects a child span for an outbound call
+	SpanStarted(span Span, serviceName, operation string)
+
+	// SpanFinished is called once the response (success or error) has been sent
+	SpanFinished(span Span, err error)
+}
+
+// reportedSpan is a single start/finish pair recorded by InMemoryReporter
+type reportedSpan struct {
+	Span        Span
+	ServiceName string
+	Operation   string
+	Err         error
+}
+
+// InMemoryReporter accumulates reported spans for inspection, primarily useful in tests
+type InMemoryReporter struct {
+	mut   sync.Mutex
+	spans []reportedSpan
+}
+
+// SpanStarted implements TraceReporter
+func (r *InMemoryReporter) SpanStarted(span Span, serviceName, operation string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.spans = append(r.spans, reportedSpan{Span: span, ServiceName: serviceName, Operation: operation})
+}
+
+// SpanFinished implements TraceReporter
+func (r *InMemoryReporter) SpanFinished(span Span, err error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for i := range r.spans {
+		if r.spans[i].Span.SpanID == span.SpanID {
+			r.spans[i].Err = err
+			return
+		}
+	}
+}
+
+// Spans returns a snapshot of every span reported so far
+func (r *InMemoryReporter) Spans() []reportedSpan {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	out := make([]reportedSpan, len(r.spans))
+	copy(out, r.spans)
+	return out
+}
+
+// zipkinSpan is the subset of the Zipkin v1 JSON span format this reporter emits
+type zipkinSpan struct {
+	TraceID  string `json:"traceId"`
+	ID       string `json:"id"`
+	ParentID string `json:"parentId,omitempty"`
+	Name     string `json:"name"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ZipkinJSONReporter renders each finished span as Zipkin-style JSON and hands it to Emit
+// for delivery (e.g. POSTing to a collector).
+type ZipkinJSONReporter struct {
+	Emit func(spanJSON []byte)
+
+	mut   sync.Mutex
+	names map[uint64]string
+}
+
+// SpanStarted implements TraceReporter
+func (z *ZipkinJSONReporter) SpanStarted(span Span, serviceName, operation string) {
+	z.mut.Lock()
+	defer z.mut.Unlock()
+
+	if z.names == nil {
+		z.names = make(map[uint64]string)
+	}
+	z.names[span.SpanID] = serviceName + "::" + operation
+}
+
+// SpanFinished implements TraceReporter
+func (z *ZipkinJSONReporter) SpanFinished(span Span, err error) {
+	if z.Emit == nil {
+		return
+	}
+
+	z.mut.Lock()
+	name := z.names[span.SpanID]
+	delete(z.names, span.SpanID)
+	z.mut.Unlock()
+
+	zs := zipkinSpan{
+		TraceID: fmt.Sprintf("%x", span.TraceID),
+		ID:      fmt.Sprintf("%x", span.SpanID),
+		Name:    name,
+	}
+	if span.ParentID != 0 {
+		zs.ParentID = fmt.Sprintf("%x", span.ParentID)
+	}
+	if err != nil {
+		zs.Error = err.Error()
+	}
+
+	b, merr := json.Marshal(zs)
+	if merr != nil {
+		return
+	}
+	z.Emit(b)
+}